@@ -0,0 +1,380 @@
+package v1
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+type ErrorStatusT int
+
+const (
+	// Routes
+	VerifyRoute      = "/verify"                                      // Verify a release file
+	BatchVerifyRoute = "/verify/batch"                                // Verify several release files of a record
+	DownloadRoute    = "/download/{key}/{file}"                       // Download a verified release file
+	RecordRoute      = "/record/{token}"                              // Inspect the integrity of a record
+	IdentityRoute    = "/identity"                                    // Retrieve the server identity
+	ConflictsRoute   = "/admin/conflicts"                             // List conflicting release declarations
+	ProductsRoute    = "/products"                                    // List the products
+	VersionsRoute    = "/products/{product}/versions"                 // List the versions of a product
+	FilesRoute       = "/products/{product}/versions/{version}/files" // List the release files of a version
+
+	BatchVerifyMax = 64 // Maximum number of files in a batch verification
+	ChallengeSize  = 32 // Size of the identity challenge in bytes
+
+	ReceiptVersion = "sumd-receipt-v1"  // Verification receipt message version
+	ChecksumSchema = "sumd-checksum-v1" // Checksum metadata payload schema
+
+	// Error status codes
+	ErrorStatusInvalid               ErrorStatusT = 0
+	ErrorStatusInvalidRequestPayload ErrorStatusT = 1
+	ErrorStatusMissingParam          ErrorStatusT = 2
+	ErrorStatusInvalidReleasePath    ErrorStatusT = 3
+	ErrorStatusUnsupportedAlgorithm  ErrorStatusT = 4
+	ErrorStatusInvalidLinkPolicy     ErrorStatusT = 5
+	ErrorStatusReleaseNotFound       ErrorStatusT = 6
+	ErrorStatusLinkNotFound          ErrorStatusT = 7
+	ErrorStatusMetadataNotFound      ErrorStatusT = 8
+	ErrorStatusChecksumMismatch      ErrorStatusT = 9
+	ErrorStatusInvalidSignature      ErrorStatusT = 10
+	ErrorStatusInternalError         ErrorStatusT = 11
+	ErrorStatusInvalidMetadata       ErrorStatusT = 12
+	ErrorStatusInvalidChallenge      ErrorStatusT = 13
+	ErrorStatusInvalidRecord         ErrorStatusT = 14
+	ErrorStatusRecordNotFound        ErrorStatusT = 15
+	ErrorStatusConflictingRecords    ErrorStatusT = 16
+	ErrorStatusUnauthorized          ErrorStatusT = 17
+	ErrorStatusInvalidRecordVersion  ErrorStatusT = 18
+	ErrorStatusLast                  ErrorStatusT = 19
+)
+
+var (
+	// ErrorStatus converts error status codes to human readable text.
+	ErrorStatus = map[ErrorStatusT]string{
+		ErrorStatusInvalid:               "invalid status",
+		ErrorStatusInvalidRequestPayload: "invalid request payload",
+		ErrorStatusMissingParam:          "required param not found",
+		ErrorStatusInvalidReleasePath:    "invalid release path",
+		ErrorStatusUnsupportedAlgorithm:  "unsupported checksum algorithm",
+		ErrorStatusInvalidLinkPolicy:     "invalid download link policy",
+		ErrorStatusReleaseNotFound:       "release file not found",
+		ErrorStatusLinkNotFound:          "download link not found",
+		ErrorStatusMetadataNotFound:      "release checksum metadata not found",
+		ErrorStatusChecksumMismatch:      "data integrity check failed",
+		ErrorStatusInvalidSignature:      "publisher signature check failed",
+		ErrorStatusInternalError:         "internal error",
+		ErrorStatusInvalidMetadata:       "invalid checksum metadata",
+		ErrorStatusInvalidChallenge:      "invalid challenge",
+		ErrorStatusInvalidRecord:         "politeia record verification failed",
+		ErrorStatusRecordNotFound:        "politeia record not found",
+		ErrorStatusConflictingRecords:    "vetted records declare conflicting checksums",
+		ErrorStatusUnauthorized:          "invalid credentials",
+		ErrorStatusInvalidRecordVersion:  "invalid record version",
+	}
+
+	ErrInvalidHex     = errors.New("corrupt hex string")
+	ErrInvalidReceipt = errors.New("receipt verification failed")
+)
+
+// ErrorReply is returned when a request fails and describes the failure.
+// Verification failures are returned as part of the VerifyReply.
+type ErrorReply struct {
+	ErrorCode    ErrorStatusT `json:"errorcode"`              // Numeric error code
+	ErrorContext []string     `json:"errorcontext,omitempty"` // Additional error information
+}
+
+// Error satisfies the error interface.
+func (e ErrorReply) Error() string {
+	if len(e.ErrorContext) == 0 {
+		return ErrorStatus[e.ErrorCode]
+	}
+	return fmt.Sprintf("%s: %v", ErrorStatus[e.ErrorCode], e.ErrorContext)
+}
+
+// VerifyRequest requests the verification of a release file against the
+// checksum metadata of a politeia record. The most recent vetted record
+// declaring the release file is used if no token is provided, and the latest
+// record version if no record version is provided. The download link limits
+// are optional and may only tighten the server's defaults.
+type VerifyRequest struct {
+	Token         string `json:"token,omitempty"`         // Censorship token, resolved if unset
+	RecordVersion string `json:"recordversion,omitempty"` // Record version, latest if unset
+	Product       string `json:"product"`                 // Product name
+	Version       string `json:"version"`                 // Release version
+	File          string `json:"file"`                    // Release filename
+	Algorithm     string `json:"algorithm,omitempty"`     // Checksum algorithm
+	SingleUse     bool   `json:"singleuse,omitempty"`     // Single download link
+	MaxDownloads  int    `json:"maxdownloads,omitempty"`  // Download limit
+	TTL           int64  `json:"ttl,omitempty"`           // Link lifetime in seconds
+}
+
+// VerifyReply describes the outcome of a release file verification, the
+// download link is only set when the release file is verified. Record
+// versions that declared different checksum metadata for the release file
+// are listed as metadata changes, a strong sign of tampering. Every reply is
+// a receipt signed by the server identity, see ReceiptMessage.
+type VerifyReply struct {
+	Token                string           `json:"token"`                        // Censorship token
+	RecordVersion        string           `json:"recordversion"`                // Record version
+	Product              string           `json:"product"`                      // Product name
+	Version              string           `json:"version"`                      // Release version
+	File                 string           `json:"file"`                         // Release filename
+	ReleaseChecksum      string           `json:"releasechecksum"`              // Release file checksum
+	DistributionChecksum string           `json:"distributionchecksum"`         // Recorded checksum
+	Algorithm            string           `json:"algorithm"`                    // Checksum algorithm
+	SignatureVerified    bool             `json:"signatureverified"`            // Publisher signature status
+	PGPSignature         string           `json:"pgpsignature,omitempty"`       // Detached signature filename
+	PGPSigner            string           `json:"pgpsigner,omitempty"`          // Signer fingerprint
+	PGPVerified          bool             `json:"pgpverified,omitempty"`        // Detached signature status
+	PGPError             string           `json:"pgperror,omitempty"`           // Detached signature failure
+	Verified             bool             `json:"verified"`                     // Verification status
+	Download             string           `json:"download,omitempty"`           // Download link
+	Expires              *time.Time       `json:"expires,omitempty"`            // Download link expiry
+	RemainingDownloads   int              `json:"remainingdownloads,omitempty"` // Download limit left
+	Error                *ErrorReply      `json:"error,omitempty"`              // Verification failure
+	Tokens               []string         `json:"tokens,omitempty"`             // Records declaring the same checksum
+	ConflictingTokens    []string         `json:"conflictingtokens,omitempty"`  // Records declaring other checksums
+	MetadataChanged      bool             `json:"metadatachanged"`              // Checksum metadata changed between record versions
+	MetadataChanges      []MetadataChange `json:"metadatachanges,omitempty"`    // Record versions declaring other checksum metadata
+	HistoryIncomplete    bool             `json:"historyincomplete,omitempty"`  // Record versions missing from the metadata change check
+	Timestamp            int64            `json:"timestamp"`                    // Verification time
+	PublicKey            string           `json:"publickey"`                    // Server public key
+	Signature            string           `json:"signature"`                    // Signature of the receipt message
+}
+
+// MetadataChange is the checksum metadata another version of a record
+// declared for a release file.
+type MetadataChange struct {
+	RecordVersion string `json:"recordversion"`       // Record version
+	Checksum      string `json:"checksum"`            // Declared checksum
+	Algorithm     string `json:"algorithm"`           // Checksum algorithm
+	Signature     string `json:"signature,omitempty"` // Declared publisher signature
+	PublicKey     string `json:"pubkey,omitempty"`    // Declared publisher public key
+}
+
+// ReceiptMessage returns the message signed by the server for a verification
+// reply. It is the receipt version followed by the token, record version,
+// product, version, file, algorithm, release checksum, distribution checksum,
+// publisher signature status, detached signature signer and status, vouching
+// and conflicting tokens, metadata change status and changes, history
+// completeness and verification status and the timestamp, each encoded as a
+// netstring ("[length]:[value],"). Lists are encoded as the netstring of
+// their sorted items' netstrings, a metadata change as the netstring of its
+// record version, checksum, algorithm, signature and public key netstrings.
+func ReceiptMessage(reply *VerifyReply) []byte {
+	changes := make([]string, 0, len(reply.MetadataChanges))
+	for _, change := range reply.MetadataChanges {
+		changes = append(changes, string(appendNetstrings(nil, change.RecordVersion,
+			change.Checksum, change.Algorithm, change.Signature, change.PublicKey)))
+	}
+	return appendNetstrings(nil,
+		ReceiptVersion,
+		reply.Token,
+		reply.RecordVersion,
+		reply.Product,
+		reply.Version,
+		reply.File,
+		reply.Algorithm,
+		reply.ReleaseChecksum,
+		reply.DistributionChecksum,
+		strconv.FormatBool(reply.SignatureVerified),
+		reply.PGPSigner,
+		strconv.FormatBool(reply.PGPVerified),
+		netstringList(reply.Tokens),
+		netstringList(reply.ConflictingTokens),
+		strconv.FormatBool(reply.MetadataChanged),
+		netstringList(changes),
+		strconv.FormatBool(reply.HistoryIncomplete),
+		strconv.FormatBool(reply.Verified),
+		strconv.FormatInt(reply.Timestamp, 10),
+	)
+}
+
+// appendNetstrings appends the fields to a message, each encoded as a
+// netstring.
+func appendNetstrings(message []byte, fields ...string) []byte {
+	for _, field := range fields {
+		message = strconv.AppendInt(message, int64(len(field)), 10)
+		message = append(message, ':')
+		message = append(message, field...)
+		message = append(message, ',')
+	}
+	return message
+}
+
+// netstringList encodes a list as its sorted items, each encoded as a
+// netstring.
+func netstringList(items []string) string {
+	sorted := append([]string{}, items...)
+	sort.Strings(sorted)
+	return string(appendNetstrings(nil, sorted...))
+}
+
+// VerifyReceipt ensures that a verification reply is signed by the provided
+// server identity.
+func VerifyReceipt(pid identity.PublicIdentity, reply *VerifyReply) error {
+	if reply.PublicKey != hex.EncodeToString(pid.Key[:]) {
+		return ErrInvalidReceipt
+	}
+	s, err := hex.DecodeString(reply.Signature)
+	if err != nil {
+		return ErrInvalidHex
+	}
+	if len(s) != identity.SignatureSize {
+		return ErrInvalidReceipt
+	}
+	var signature [identity.SignatureSize]byte
+	copy(signature[:], s)
+	if !pid.VerifyMessage(ReceiptMessage(reply), signature) {
+		return ErrInvalidReceipt
+	}
+	return nil
+}
+
+// BatchFile identifies a release file of a batch verification.
+type BatchFile struct {
+	Product   string `json:"product"`             // Product name
+	Version   string `json:"version"`             // Release version
+	File      string `json:"file"`                // Release filename
+	Algorithm string `json:"algorithm,omitempty"` // Checksum algorithm
+}
+
+// BatchVerifyRequest requests the verification of several release files
+// against the checksum metadata of a single politeia record. Every release
+// file described by the record is verified if All is set.
+type BatchVerifyRequest struct {
+	Token        string      `json:"token"`                  // Censorship token
+	Files        []BatchFile `json:"files,omitempty"`        // Release files
+	All          bool        `json:"all,omitempty"`          // Verify all record files
+	SingleUse    bool        `json:"singleuse,omitempty"`    // Single download links
+	MaxDownloads int         `json:"maxdownloads,omitempty"` // Download limit
+	TTL          int64       `json:"ttl,omitempty"`          // Link lifetime in seconds
+}
+
+// BatchVerifyResult is the outcome of a single release file verification,
+// either the reply or the error is set.
+type BatchVerifyResult struct {
+	Product string       `json:"product"`         // Product name
+	Version string       `json:"version"`         // Release version
+	File    string       `json:"file"`            // Release filename
+	Reply   *VerifyReply `json:"reply,omitempty"` // Verification reply
+	Error   *ErrorReply  `json:"error,omitempty"` // Request failure
+}
+
+// BatchVerifyReply returns the per file results of a batch verification in
+// request order.
+type BatchVerifyReply struct {
+	Token   string              `json:"token"`   // Censorship token
+	Results []BatchVerifyResult `json:"results"` // Verification results
+}
+
+// StreamReport describes the integrity of a single record metadata stream.
+// Streams other than the server's checksum metadata streams are reported
+// without checksum metadata or error, invalid checksum metadata is reported
+// with an error and the release file of valid checksum metadata is verified
+// without issuing a download link.
+type StreamReport struct {
+	ID               uint64       `json:"id"`                  // Metadata stream ID
+	ChecksumMetadata bool         `json:"checksummetadata"`    // Valid checksum metadata
+	Product          string       `json:"product,omitempty"`   // Product name
+	Version          string       `json:"version,omitempty"`   // Release version
+	File             string       `json:"file,omitempty"`      // Release filename
+	Algorithm        string       `json:"algorithm,omitempty"` // Checksum algorithm
+	Reply            *VerifyReply `json:"reply,omitempty"`     // Verification reply
+	Error            *ErrorReply  `json:"error,omitempty"`     // Stream failure
+}
+
+// RecordReply is the integrity report of every metadata stream of a vetted
+// politeia record. The record is verified if it holds checksum metadata,
+// none of its checksum metadata streams are invalid and every release file
+// it describes is verified.
+type RecordReply struct {
+	Token     string         `json:"token"`     // Censorship token
+	Version   string         `json:"version"`   // Record version
+	Timestamp int64          `json:"timestamp"` // Last record update
+	Verified  bool           `json:"verified"`  // Record verification status
+	Streams   []StreamReport `json:"streams"`   // Metadata stream reports
+}
+
+// Identity requests the server identity.
+type Identity struct {
+	Challenge string `json:"challenge"` // Random challenge
+}
+
+// IdentityReply contains the server public identity.
+type IdentityReply struct {
+	Response  string `json:"response"`  // Signature of Challenge
+	PublicKey string `json:"publickey"` // Public key
+}
+
+// ReleaseDeclaration is a vetted record's checksum declaration for a release
+// file as seen by the inventory sync.
+type ReleaseDeclaration struct {
+	Token     string `json:"token"`     // Censorship token
+	Timestamp int64  `json:"timestamp"` // Record timestamp
+	Checksum  string `json:"checksum"`  // Declared checksum
+	Algorithm string `json:"algorithm"` // Checksum algorithm
+	FirstSeen int64  `json:"firstseen"` // First sync declaring the checksum
+	LastSeen  int64  `json:"lastseen"`  // Last sync declaring the checksum
+}
+
+// ReleaseConflict lists the vetted records declaring a release file.
+type ReleaseConflict struct {
+	Product      string               `json:"product"`      // Product name
+	Version      string               `json:"version"`      // Release version
+	File         string               `json:"file"`         // Release filename
+	Declarations []ReleaseDeclaration `json:"declarations"` // Checksum declarations
+}
+
+// ConflictsReply lists the release files declared with different checksums
+// and the release files declared with the same checksum by several records
+// as of the last inventory sync.
+type ConflictsReply struct {
+	Synced     int64             `json:"synced"`     // Last inventory sync
+	Conflicts  []ReleaseConflict `json:"conflicts"`  // Conflicting declarations
+	Duplicates []ReleaseConflict `json:"duplicates"` // Duplicate declarations
+}
+
+// ProductsReply lists the products of the release store.
+type ProductsReply struct {
+	Products []string `json:"products"` // Product names
+}
+
+// VersionsReply lists the release versions of a product.
+type VersionsReply struct {
+	Product  string   `json:"product"`  // Product name
+	Versions []string `json:"versions"` // Release versions
+}
+
+// CatalogFile describes a release file of the catalog. The file is checksummed
+// with the default algorithm and every algorithm vetted records declare it
+// with, it is vetted if a vetted record declares a matching checksum and no
+// vetted record declares another. Checksums are computed in the background,
+// the file is pending and only holds the computed checksums until then.
+type CatalogFile struct {
+	File              string            `json:"file"`                        // Release filename
+	Size              int64             `json:"size"`                        // File size in bytes
+	ModTime           int64             `json:"modtime"`                     // Last modification
+	Checksums         map[string]string `json:"checksums,omitempty"`         // Checksums keyed by algorithm
+	Pending           bool              `json:"pending,omitempty"`           // Checksums being computed
+	Vetted            bool              `json:"vetted"`                      // Vetted record match
+	Tokens            []string          `json:"tokens,omitempty"`            // Records declaring a matching checksum
+	ConflictingTokens []string          `json:"conflictingtokens,omitempty"` // Records declaring other checksums
+	Error             *ErrorReply       `json:"error,omitempty"`             // Checksum failure
+}
+
+// FilesReply lists the release files of a product version. Vetted records
+// are only matched if the server indexes vetted records, as of the last
+// inventory sync.
+type FilesReply struct {
+	Product string        `json:"product"`          // Product name
+	Version string        `json:"version"`          // Release version
+	Synced  int64         `json:"synced,omitempty"` // Last inventory sync, unset if not indexed
+	Files   []CatalogFile `json:"files"`            // Release files
+}
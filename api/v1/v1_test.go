@@ -0,0 +1,108 @@
+package v1
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+// testReceipt returns a verification reply signed by the provided identity
+func testReceipt(fi *identity.FullIdentity) *VerifyReply {
+	reply := &VerifyReply{
+		Token:                "token",
+		RecordVersion:        "2",
+		Product:              "mounty",
+		Version:              "1.7",
+		File:                 "mounty.dmg",
+		ReleaseChecksum:      "5ecc",
+		DistributionChecksum: "5ecc",
+		Algorithm:            "sha256",
+		SignatureVerified:    true,
+		PGPSigner:            "fingerprint",
+		PGPVerified:          true,
+		Verified:             true,
+		Tokens:               []string{"token", "other"},
+		ConflictingTokens:    []string{"conflicting", "forged"},
+		MetadataChanged:      true,
+		MetadataChanges: []MetadataChange{
+			{RecordVersion: "1", Checksum: "ba5e", Algorithm: "sha256"},
+			{RecordVersion: "0", Checksum: "f00d", Algorithm: "sha256"},
+		},
+		Timestamp: 1500000000,
+		PublicKey: hex.EncodeToString(fi.Public.Key[:]),
+	}
+	signature := fi.SignMessage(ReceiptMessage(reply))
+	reply.Signature = hex.EncodeToString(signature[:])
+	return reply
+}
+
+func TestVerifyReceipt(t *testing.T) {
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		pid    identity.PublicIdentity
+		modify func(reply *VerifyReply)
+		err    error
+	}{
+		{"signed receipt", fi.Public, func(reply *VerifyReply) {}, nil},
+		{"reordered lists", fi.Public, func(reply *VerifyReply) {
+			// lists are signed sorted
+			reply.Tokens[0], reply.Tokens[1] = reply.Tokens[1], reply.Tokens[0]
+			reply.MetadataChanges[0], reply.MetadataChanges[1] =
+				reply.MetadataChanges[1], reply.MetadataChanges[0]
+		}, nil},
+		{"other identity", other.Public, func(reply *VerifyReply) {}, ErrInvalidReceipt},
+		{"other public key", fi.Public, func(reply *VerifyReply) {
+			reply.PublicKey = hex.EncodeToString(other.Public.Key[:])
+		}, ErrInvalidReceipt},
+		{"non hex signature", fi.Public, func(reply *VerifyReply) {
+			reply.Signature = "zz" + reply.Signature[2:]
+		}, ErrInvalidHex},
+		{"truncated signature", fi.Public, func(reply *VerifyReply) {
+			reply.Signature = reply.Signature[2:]
+		}, ErrInvalidReceipt},
+		{"modified checksum", fi.Public, func(reply *VerifyReply) {
+			reply.ReleaseChecksum = "ba5e"
+		}, ErrInvalidReceipt},
+		{"modified verification", fi.Public, func(reply *VerifyReply) {
+			reply.Verified = false
+		}, ErrInvalidReceipt},
+		{"modified signer", fi.Public, func(reply *VerifyReply) {
+			reply.PGPSigner = "other"
+		}, ErrInvalidReceipt},
+		{"dropped token", fi.Public, func(reply *VerifyReply) {
+			reply.Tokens = reply.Tokens[:1]
+		}, ErrInvalidReceipt},
+		{"moved token", fi.Public, func(reply *VerifyReply) {
+			// netstrings keep list items and fields apart
+			reply.Tokens = append(reply.Tokens, reply.ConflictingTokens[0])
+			reply.ConflictingTokens = reply.ConflictingTokens[1:]
+		}, ErrInvalidReceipt},
+		{"dropped conflicting token", fi.Public, func(reply *VerifyReply) {
+			reply.ConflictingTokens = nil
+		}, ErrInvalidReceipt},
+		{"modified metadata change", fi.Public, func(reply *VerifyReply) {
+			reply.MetadataChanges[1].Checksum = "5ecc"
+		}, ErrInvalidReceipt},
+		{"modified timestamp", fi.Public, func(reply *VerifyReply) {
+			reply.Timestamp++
+		}, ErrInvalidReceipt},
+	}
+	for _, test := range tests {
+		reply := testReceipt(fi)
+		test.modify(reply)
+		err := VerifyReceipt(test.pid, reply)
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
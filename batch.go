@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	api "github.com/dnldd/sumd/api/v1"
+)
+
+// batchConcurrency is the number of release files hashed concurrently for
+// a batch verification
+const batchConcurrency = 4
+
+// concurrently runs a task for each index in [0, n), at most
+// batchConcurrency tasks run at once
+func concurrently(n int, task func(i int)) {
+	semaphore := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			task(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// verifyBatch verifies several release files against a single record, the
+// record is fetched once and the release files are hashed concurrently
+func (sumd *Sumd) verifyBatch(ctx context.Context, request *api.BatchVerifyRequest, policy *LinkPolicy) (*api.BatchVerifyReply, error) {
+	record, err := sumd.fetchRecord(ctx, request.Token, "")
+	if err != nil {
+		return nil, err
+	}
+	history, err := sumd.recordHistory(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	// list the release files described by the record's checksum metadata,
+	// unrelated and invalid streams are skipped
+	files := request.Files
+	if request.All {
+		files = []api.BatchFile{}
+		for i := range record.Metadata {
+			metadata, err := sumd.Streams.Parse(&record.Metadata[i])
+			if err != nil {
+				continue
+			}
+			files = append(files, api.BatchFile{
+				Product:   metadata.Product,
+				Version:   metadata.Version,
+				File:      metadata.File,
+				Algorithm: metadata.Algorithm,
+			})
+		}
+	}
+
+	results := make([]api.BatchVerifyResult, len(files))
+	for i, file := range files {
+		results[i] = api.BatchVerifyResult{
+			Product: file.Product,
+			Version: file.Version,
+			File:    file.File,
+		}
+	}
+	concurrently(len(files), func(i int) {
+		reply, err := sumd.verifyBatchFile(record, history, files[i], policy)
+		if err != nil {
+			_, results[i].Error = errorReply(err)
+			return
+		}
+		results[i].Reply = reply
+	})
+
+	return &api.BatchVerifyReply{
+		Token:   request.Token,
+		Results: results,
+	}, nil
+}
+
+// verifyBatchFile verifies a single release file of a batch verification
+func (sumd *Sumd) verifyBatchFile(record *v1.Record, history *RecordHistory, file api.BatchFile, policy *LinkPolicy) (*api.VerifyReply, error) {
+	err := ValidateReleasePath(file.Product, file.Version, file.File)
+	if err != nil {
+		return nil, err
+	}
+	if file.Algorithm != "" {
+		_, err := NewHasher(file.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadata, err := sumd.findMetadata(record, file.Product, file.Version, file.File, file.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return sumd.verifyRelease(record, history, metadata, policy)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/gorilla/mux"
+)
+
+// testBatch serves batch verifications of a cached record declaring the
+// test release file and a windows release file with a tampered checksum
+func testBatch(t *testing.T) (*mux.Router, func()) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": testReleaseContent,
+		"mounty/1.7/mounty.exe": "windows release",
+	})
+	fi, err := identity.New()
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+	records, err := NewRecordCache(nil, nil, time.Hour, 0, 100)
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+	record := &v1.Record{
+		Status:           v1.RecordStatusPublic,
+		Version:          "1",
+		CensorshipRecord: v1.CensorshipRecord{Token: "token"},
+		Metadata: []v1.MetadataStream{{
+			ID: 1,
+			Payload: fmt.Sprintf(`{"checksum":"%s","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+				testDigest(testReleaseContent)),
+		}, {
+			ID: 1,
+			Payload: fmt.Sprintf(`{"checksum":"%s","product":"mounty","version":"1.7","file":"mounty.exe"}`,
+				testDigest("tampered release contents")),
+		}},
+	}
+	err = records.Put("token", "", record, time.Now())
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+
+	previous := sumd
+	sumd = &Sumd{
+		Args:    &Args{LinkTTL: time.Hour},
+		Fi:      fi,
+		Links:   NewMemoryLinkStore(),
+		Store:   NewFileReleaseStore(root),
+		Records: records,
+		Streams: ChecksumStreams{1: true},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc(api.BatchVerifyRoute, BatchVerifyChecksum).Methods("POST")
+	return router, func() {
+		sumd = previous
+		os.RemoveAll(root)
+	}
+}
+
+// batchVerify requests a batch verification
+func batchVerify(t *testing.T, router *mux.Router, batchRequest *api.BatchVerifyRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(batchRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, api.BatchVerifyRoute, bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestBatchVerify(t *testing.T) {
+	router, done := testBatch(t)
+	defer done()
+
+	// per file failures are reported in the results, the batch succeeds
+	recorder := batchVerify(t, router, &api.BatchVerifyRequest{
+		Token: "token",
+		Files: []api.BatchFile{
+			{Product: "mounty", Version: "1.7", File: "mounty.dmg"},
+			{Product: "mounty", Version: "1.7", File: "mounty.exe"},
+			{Product: "mounty", Version: "..", File: "mounty.dmg"},
+			{Product: "mounty", Version: "1.8", File: "mounty.dmg"},
+			{Product: "mounty", Version: "1.7", File: "mounty.dmg", Algorithm: "md4"},
+		},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	batchReply := &api.BatchVerifyReply{}
+	err := json.Unmarshal(recorder.Body.Bytes(), batchReply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		verified bool
+		reply    api.ErrorStatusT
+		err      api.ErrorStatusT
+	}{
+		{"matching checksum", true, 0, 0},
+		{"mismatched checksum", false, api.ErrorStatusChecksumMismatch, 0},
+		{"invalid path", false, 0, api.ErrorStatusInvalidReleasePath},
+		{"undeclared file", false, 0, api.ErrorStatusMetadataNotFound},
+		{"unsupported algorithm", false, 0, api.ErrorStatusUnsupportedAlgorithm},
+	}
+	if len(batchReply.Results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(batchReply.Results), len(tests))
+	}
+	for i, test := range tests {
+		result := batchReply.Results[i]
+		if test.err != 0 {
+			if result.Error == nil || result.Error.ErrorCode != test.err || result.Reply != nil {
+				t.Errorf("%s: got %+v, want error %d", test.name, result, test.err)
+			}
+			continue
+		}
+		if result.Error != nil || result.Reply == nil {
+			t.Errorf("%s: got error %+v, want a reply", test.name, result.Error)
+			continue
+		}
+		if result.Reply.Verified != test.verified {
+			t.Errorf("%s: got verified %v, want %v", test.name, result.Reply.Verified, test.verified)
+		}
+		if test.reply != 0 && (result.Reply.Error == nil || result.Reply.Error.ErrorCode != test.reply) {
+			t.Errorf("%s: got reply error %+v, want %d", test.name, result.Reply.Error, test.reply)
+		}
+	}
+}
+
+func TestBatchVerifyAll(t *testing.T) {
+	router, done := testBatch(t)
+	defer done()
+
+	recorder := batchVerify(t, router, &api.BatchVerifyRequest{Token: "token", All: true})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	batchReply := &api.BatchVerifyReply{}
+	err := json.Unmarshal(recorder.Body.Bytes(), batchReply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchReply.Results) != 2 || batchReply.Results[0].File != "mounty.dmg" ||
+		batchReply.Results[1].File != "mounty.exe" {
+		t.Fatalf("got results %+v, want the declared release files", batchReply.Results)
+	}
+}
+
+func TestBatchVerifyMax(t *testing.T) {
+	router, done := testBatch(t)
+	defer done()
+
+	files := make([]api.BatchFile, api.BatchVerifyMax+1)
+	for i := range files {
+		files[i] = api.BatchFile{Product: "mounty", Version: "1.7", File: "mounty.dmg"}
+	}
+	recorder := batchVerify(t, router, &api.BatchVerifyRequest{Token: "token", Files: files})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for %d files, want %d", recorder.Code, len(files), http.StatusBadRequest)
+	}
+
+	recorder = batchVerify(t, router, &api.BatchVerifyRequest{Token: "token", Files: files[:api.BatchVerifyMax]})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d for %d files, want %d", recorder.Code, api.BatchVerifyMax, http.StatusOK)
+	}
+}
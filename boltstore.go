@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// the database filename
+	dbFilename = "sumd.db"
+	// the download link bucket
+	linkBucket = []byte("links")
+)
+
+// OpenDB opens the sumd database in the provided data directory, creating
+// the directory if it does not exist
+func OpenDB(dataDir string) (*bolt.DB, error) {
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return bolt.Open(filepath.Join(dataDir, dbFilename), 0600,
+		&bolt.Options{Timeout: time.Second * 5})
+}
+
+// BoltLinkStore is a link store persisted to disk
+type BoltLinkStore struct {
+	// the database
+	db *bolt.DB
+}
+
+// Constructor, expired links are garbage collected on creation
+func NewBoltLinkStore(db *bolt.DB) (*BoltLinkStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(linkBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store := &BoltLinkStore{db: db}
+	_, err = store.Sweep(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Put stores a release under the provided key
+func (store *BoltLinkStore) Put(key string, release *CachedRelease) error {
+	releaseBytes, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(linkBucket).Put([]byte(key), releaseBytes)
+	})
+}
+
+// Get fetches the unexpired release stored under the provided key
+func (store *BoltLinkStore) Get(key string) (*CachedRelease, error) {
+	release := &CachedRelease{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		releaseBytes := tx.Bucket(linkBucket).Get([]byte(key))
+		if releaseBytes == nil {
+			return ErrLinkNotFound
+		}
+		return json.Unmarshal(releaseBytes, release)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if release.expired(time.Now()) {
+		return nil, ErrLinkNotFound
+	}
+	return release, nil
+}
+
+// Delete removes the release stored under the provided key
+func (store *BoltLinkStore) Delete(key string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(linkBucket).Delete([]byte(key))
+	})
+}
+
+// update atomically applies an update to the release stored under the
+// provided key, the release is not stored if the update fails
+func (store *BoltLinkStore) update(key string, update func(release *CachedRelease) error) (*CachedRelease, error) {
+	release := &CachedRelease{}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(linkBucket)
+		releaseBytes := bucket.Get([]byte(key))
+		if releaseBytes == nil {
+			return ErrLinkNotFound
+		}
+		err := json.Unmarshal(releaseBytes, release)
+		if err != nil {
+			return err
+		}
+
+		err = update(release)
+		if err != nil {
+			return err
+		}
+		releaseBytes, err = json.Marshal(release)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), releaseBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// Consume atomically records a download of the release stored under the
+// provided key
+func (store *BoltLinkStore) Consume(key string, now time.Time) (*CachedRelease, error) {
+	return store.update(key, func(release *CachedRelease) error {
+		if release.expired(now) {
+			return ErrLinkNotFound
+		}
+		if release.exhausted() {
+			return ErrLinkExhausted
+		}
+		release.Downloads++
+		return nil
+	})
+}
+
+// Resume atomically records a resumed download of the release stored under
+// the provided key
+func (store *BoltLinkStore) Resume(key string, now time.Time, offset int64) (*CachedRelease, error) {
+	return store.update(key, func(release *CachedRelease) error {
+		if release.expired(now) {
+			return ErrLinkNotFound
+		}
+		if !release.resumable(offset) {
+			return ErrResumeRejected
+		}
+		release.Resumes++
+		return nil
+	})
+}
+
+// Advance records that the release stored under the provided key was served
+// from the start to the end byte offset
+func (store *BoltLinkStore) Advance(key string, start int64, end int64) error {
+	_, err := store.update(key, func(release *CachedRelease) error {
+		release.advance(start, end)
+		return nil
+	})
+	return err
+}
+
+// Sweep removes all releases expired by the provided time
+func (store *BoltLinkStore) Sweep(now time.Time) (int, error) {
+	removed := 0
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(linkBucket)
+		expired := [][]byte{}
+		err := bucket.ForEach(func(key []byte, releaseBytes []byte) error {
+			release := &CachedRelease{}
+			err := json.Unmarshal(releaseBytes, release)
+			if err != nil || release.expired(now) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys cannot be deleted while iterating the bucket
+		for _, key := range expired {
+			err := bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
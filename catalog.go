@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/dnldd/sumd/api/v1"
+)
+
+// listReleasePath lists the entries below a release path that are valid
+// release path elements, either directories or files, ordered by name
+func (sumd *Sumd) listReleasePath(field string, dirs bool, path ...string) ([]ReleaseInfo, error) {
+	err := validateListPath(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := sumd.Store.List(path...)
+	if err != nil {
+		return nil, err
+	}
+	entries := []ReleaseInfo{}
+	for _, info := range infos {
+		if info.IsDir != dirs || validatePathElement(field, info.Name) != nil {
+			continue
+		}
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// releaseNames returns the names of release store entries
+func releaseNames(infos []ReleaseInfo) []string {
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+// listProducts lists the products of the release store
+func (sumd *Sumd) listProducts() (*api.ProductsReply, error) {
+	products, err := sumd.listReleasePath("product", true)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ProductsReply{Products: releaseNames(products)}, nil
+}
+
+// listVersions lists the release versions of a product
+func (sumd *Sumd) listVersions(product string) (*api.VersionsReply, error) {
+	versions, err := sumd.listReleasePath("version", true, product)
+	if err != nil {
+		return nil, err
+	}
+	return &api.VersionsReply{Product: product, Versions: releaseNames(versions)}, nil
+}
+
+// catalogQueueSize is the number of release files the catalog hasher
+// queues for hashing
+const catalogQueueSize = 256
+
+// catalogJob is a release file queued for hashing by the catalog hasher
+type catalogJob struct {
+	// the product name
+	product string
+	// the version
+	version string
+	// the release filename
+	file string
+	// the algorithms to hash the release file with
+	algorithms []string
+}
+
+// catalogFailure describes a release file revision that could not be
+// hashed
+type catalogFailure struct {
+	// the file size
+	size int64
+	// the file modification time
+	modTime time.Time
+	// the hashing failure
+	err error
+}
+
+// CatalogHasher hashes the release files listed by the release catalog in
+// the background, catalog requests only report checksums already computed.
+// Checksums are recorded in the checksum index of the release directory or
+// in memory for other release stores.
+type CatalogHasher struct {
+	// the release store
+	store ReleaseStore
+	// the checksum index
+	index *ChecksumIndex
+	// the hasher lock
+	mtx sync.Mutex
+	// the release files queued for hashing, keyed by release key
+	pending map[string]struct{}
+	// the release files that could not be hashed, keyed by release key
+	failures map[string]catalogFailure
+	// the hashing queue
+	queue chan catalogJob
+	// the shutdown signal
+	quit chan struct{}
+}
+
+// Constructor, checksums are recorded in memory if no checksum index is
+// provided
+func NewCatalogHasher(store ReleaseStore, index *ChecksumIndex) *CatalogHasher {
+	if index == nil {
+		index = newChecksumCache()
+	}
+	hasher := &CatalogHasher{
+		store:    store,
+		index:    index,
+		pending:  map[string]struct{}{},
+		failures: map[string]catalogFailure{},
+		queue:    make(chan catalogJob, catalogQueueSize),
+		quit:     make(chan struct{}),
+	}
+	go hasher.hashFiles()
+	return hasher
+}
+
+// Close stops hashing release files
+func (hasher *CatalogHasher) Close() {
+	close(hasher.quit)
+}
+
+// Checksums fetches the computed checksums of a release file, the file is
+// queued for hashing and reported pending if a checksum is missing. The
+// failure of the last attempt at hashing the file is returned instead if
+// the file is unchanged since.
+func (hasher *CatalogHasher) Checksums(product string, version string, info *ReleaseInfo, algorithms []string) (map[string]string, bool, error) {
+	key := releaseKey(product, version, info.Name)
+	checksums := make(map[string]string, len(algorithms))
+	missing := []string{}
+	for _, algorithm := range algorithms {
+		checksum, ok := hasher.index.Lookup(key, info, algorithm)
+		if ok {
+			checksums[algorithm] = checksum
+		} else {
+			missing = append(missing, algorithm)
+		}
+	}
+	if len(missing) == 0 {
+		return checksums, false, nil
+	}
+
+	hasher.mtx.Lock()
+	defer hasher.mtx.Unlock()
+	failure, ok := hasher.failures[key]
+	if ok && failure.size == info.Size && failure.modTime.Equal(info.ModTime) {
+		return checksums, false, failure.err
+	}
+	if _, queued := hasher.pending[key]; !queued {
+		select {
+		case hasher.queue <- catalogJob{product: product, version: version, file: info.Name, algorithms: missing}:
+			hasher.pending[key] = struct{}{}
+		default:
+			// the queue is full, the file is queued by a later request
+		}
+	}
+	return checksums, true, nil
+}
+
+// hashFiles hashes queued release files
+func (hasher *CatalogHasher) hashFiles() {
+	for {
+		select {
+		case job := <-hasher.queue:
+			key := releaseKey(job.product, job.version, job.file)
+			info, err := hasher.hash(job)
+			if err != nil {
+				log.Printf("failed to hash %s: %s", key, err)
+			}
+
+			hasher.mtx.Lock()
+			delete(hasher.pending, key)
+			delete(hasher.failures, key)
+			if err != nil && info != nil {
+				hasher.failures[key] = catalogFailure{
+					size:    info.Size,
+					modTime: info.ModTime,
+					err:     err,
+				}
+			}
+			hasher.mtx.Unlock()
+
+		case <-hasher.quit:
+			return
+		}
+	}
+}
+
+// hash computes and records the checksums of a queued release file in a
+// single read, files modified while being hashed are not recorded. It
+// returns the hashed revision of the file.
+func (hasher *CatalogHasher) hash(job catalogJob) (*ReleaseInfo, error) {
+	before, err := hasher.store.Stat(job.product, job.version, job.file)
+	if err != nil {
+		return nil, err
+	}
+	file, err := hasher.store.Open(job.product, job.version, job.file)
+	if err != nil {
+		return before, err
+	}
+	defer file.Close()
+
+	hashes := make([]hash.Hash, len(job.algorithms))
+	writers := make([]io.Writer, len(job.algorithms))
+	for i, algorithm := range job.algorithms {
+		hashes[i], err = NewHasher(algorithm)
+		if err != nil {
+			return before, err
+		}
+		writers[i] = hashes[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return before, err
+	}
+	after, err := hasher.store.Stat(job.product, job.version, job.file)
+	if err != nil {
+		return nil, err
+	}
+	if before.Size != after.Size || !before.ModTime.Equal(after.ModTime) {
+		return nil, nil
+	}
+
+	key := releaseKey(job.product, job.version, job.file)
+	for i, algorithm := range job.algorithms {
+		hasher.index.Update(key, after, algorithm, hex.EncodeToString(hashes[i].Sum(nil)))
+	}
+	return after, nil
+}
+
+// listFiles lists the release files of a product version with their
+// computed checksums, matched against the vetted release index
+func (sumd *Sumd) listFiles(product string, version string) (*api.FilesReply, error) {
+	files, err := sumd.listReleasePath("file", false, product, version)
+	if err != nil {
+		return nil, err
+	}
+
+	filesReply := &api.FilesReply{
+		Product: product,
+		Version: version,
+		Files:   make([]api.CatalogFile, len(files)),
+	}
+	if sumd.Releases != nil {
+		filesReply.Synced = sumd.Releases.Synced().Unix()
+	}
+	for i := range files {
+		filesReply.Files[i] = sumd.catalogFile(product, version, &files[i])
+	}
+	return filesReply, nil
+}
+
+// catalogFile describes a release file with its checksums of the default
+// algorithm and the algorithms of its vetted checksum declarations, the
+// file is pending until they are computed
+func (sumd *Sumd) catalogFile(product string, version string, info *ReleaseInfo) api.CatalogFile {
+	catalogFile := api.CatalogFile{
+		File:    info.Name,
+		Size:    info.Size,
+		ModTime: info.ModTime.Unix(),
+	}
+	declarations := []api.ReleaseDeclaration{}
+	if sumd.Releases != nil {
+		declarations = sumd.Releases.Lookup(product, version, info.Name)
+	}
+	algorithms := []string{DefaultAlgorithm}
+	supported := map[string]bool{DefaultAlgorithm: true}
+	for _, declaration := range declarations {
+		if _, ok := supported[declaration.Algorithm]; ok {
+			continue
+		}
+		_, err := NewHasher(declaration.Algorithm)
+		supported[declaration.Algorithm] = err == nil
+		if err == nil {
+			algorithms = append(algorithms, declaration.Algorithm)
+		}
+	}
+
+	checksums, pending, err := sumd.Catalog.Checksums(product, version, info, algorithms)
+	if len(checksums) > 0 {
+		catalogFile.Checksums = checksums
+	}
+	catalogFile.Pending = pending
+	if err != nil {
+		_, catalogFile.Error = errorReply(err)
+		return catalogFile
+	}
+
+	for _, declaration := range declarations {
+		checksum, ok := checksums[declaration.Algorithm]
+		if !ok && supported[declaration.Algorithm] {
+			// the checksum is pending
+			continue
+		}
+		if checksum == declaration.Checksum {
+			catalogFile.Tokens = append(catalogFile.Tokens, declaration.Token)
+		} else {
+			catalogFile.ConflictingTokens = append(catalogFile.ConflictingTokens, declaration.Token)
+		}
+	}
+	catalogFile.Vetted = !pending && len(catalogFile.Tokens) > 0 && len(catalogFile.ConflictingTokens) == 0
+	return catalogFile
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/gorilla/mux"
+)
+
+// testCatalog serves the catalog of a release directory holding the test
+// release file, the file is declared by a vetted record
+func testCatalog(t *testing.T) (string, func()) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": testReleaseContent,
+	})
+	streams := ChecksumStreams{1: true}
+	releases, err := NewReleaseIndex(nil, streams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(testReleaseContent))
+	err = releases.Sync([]v1.Record{{
+		Status:    v1.RecordStatusPublic,
+		Timestamp: time.Now().Unix(),
+		CensorshipRecord: v1.CensorshipRecord{
+			Token: "token",
+		},
+		Metadata: []v1.MetadataStream{{
+			ID: 1,
+			Payload: fmt.Sprintf(`{"checksum":"%x","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+				digest),
+		}},
+	}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := sumd
+	store := NewFileReleaseStore(root)
+	sumd = &Sumd{
+		Args:     &Args{},
+		Store:    store,
+		Catalog:  NewCatalogHasher(store, nil),
+		Streams:  streams,
+		Releases: releases,
+	}
+	catalog := sumd.Catalog
+	return root, func() {
+		catalog.Close()
+		sumd = previous
+		os.RemoveAll(root)
+	}
+}
+
+// hashedCatalogFile lists the test release file until it is no longer
+// pending
+func hashedCatalogFile(t *testing.T) api.CatalogFile {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		filesReply, err := sumd.listFiles("mounty", "1.7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filesReply.Files) != 1 {
+			t.Fatalf("got %d files, want 1", len(filesReply.Files))
+		}
+		file := filesReply.Files[0]
+		if !file.Pending {
+			return file
+		}
+		if file.Vetted {
+			t.Fatal("pending release file reported vetted")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("release file still pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListFilesPending(t *testing.T) {
+	root, done := testCatalog(t)
+	defer done()
+	digest := sha256.Sum256([]byte(testReleaseContent))
+
+	filesReply, err := sumd.listFiles("mounty", "1.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filesReply.Files[0]
+	if !file.Pending || file.Vetted || len(file.Checksums) != 0 {
+		t.Fatalf("got %+v, want a pending release file", file)
+	}
+
+	file = hashedCatalogFile(t)
+	if file.Checksums[DefaultAlgorithm] != hex.EncodeToString(digest[:]) {
+		t.Fatalf("got checksum %s, want %x", file.Checksums[DefaultAlgorithm], digest)
+	}
+	if !file.Vetted || len(file.Tokens) != 1 || file.Error != nil {
+		t.Fatalf("got %+v, want a vetted release file", file)
+	}
+
+	// a modified release file is hashed again
+	path := filepath.Join(root, "mounty", "1.7", "mounty.dmg")
+	err = ioutil.WriteFile(path, []byte("tampered release contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesReply, err = sumd.listFiles("mounty", "1.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filesReply.Files[0].Pending {
+		t.Fatalf("got %+v, want a pending release file", filesReply.Files[0])
+	}
+	file = hashedCatalogFile(t)
+	if file.Vetted || len(file.ConflictingTokens) != 1 {
+		t.Fatalf("got %+v, want a release file conflicting with its record", file)
+	}
+}
+
+func TestListReleaseNotFound(t *testing.T) {
+	_, done := testCatalog(t)
+	defer done()
+	router := mux.NewRouter()
+	router.HandleFunc(api.VersionsRoute, ListVersions).Methods("GET")
+	router.HandleFunc(api.FilesRoute, ListFiles).Methods("GET")
+
+	tests := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"versions", "/products/mounty/versions", http.StatusOK},
+		{"versions of an unknown product", "/products/missing/versions", http.StatusNotFound},
+		{"files", "/products/mounty/versions/1.7/files", http.StatusOK},
+		{"files of an unknown product", "/products/missing/versions/1.7/files", http.StatusNotFound},
+		{"files of an unknown version", "/products/mounty/versions/1.8/files", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if recorder.Code != test.code {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.code)
+			continue
+		}
+		if test.code == http.StatusOK {
+			continue
+		}
+		errorReply := &api.ErrorReply{}
+		err := json.Unmarshal(recorder.Body.Bytes(), errorReply)
+		if err != nil || errorReply.ErrorCode != api.ErrorStatusReleaseNotFound {
+			t.Errorf("%s: got %s, want error %d", test.name, recorder.Body.String(), api.ErrorStatusReleaseNotFound)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// the checksum index bucket
+	checksumBucket = []byte("checksums")
+)
+
+// indexEntry describes the checksums of a release file at the time it was
+// hashed
+type indexEntry struct {
+	// the file size
+	Size int64 `json:"size"`
+	// the file modification time
+	ModTime time.Time `json:"modtime"`
+	// the hex encoded checksums, keyed by algorithm
+	Checksums map[string]string `json:"checksums"`
+}
+
+// fresh asserts if an index entry still describes the provided file
+func (entry *indexEntry) fresh(info *ReleaseInfo) bool {
+	return entry.Size == info.Size && entry.ModTime.Equal(info.ModTime)
+}
+
+// ChecksumIndex maintains precomputed checksums of the files in the release
+// directory, it watches the directory for changes and rehashes updated files
+// in the background. Files are hashed with the default algorithm, checksums
+// of other algorithms are recorded as they are computed on demand. Entries
+// are keyed by release key
+type ChecksumIndex struct {
+	// the release directory
+	root string
+	// the index lock
+	mtx sync.RWMutex
+	// the indexed checksums, keyed by release key
+	entries map[string]indexEntry
+	// the paths queued for hashing
+	pending map[string]struct{}
+	// the hashing queue
+	queue chan string
+	// the release directory watcher
+	watcher *fsnotify.Watcher
+	// the shutdown signal
+	quit chan struct{}
+	// the database, only set if the index is persisted
+	db *bolt.DB
+}
+
+// Constructor, the release directory is scanned in the background. If a
+// database is provided the persisted checksums are loaded and files
+// unchanged since they were hashed are not hashed again.
+func NewChecksumIndex(root string, db *bolt.DB) (*ChecksumIndex, error) {
+	index := &ChecksumIndex{
+		root:    root,
+		entries: map[string]indexEntry{},
+		pending: map[string]struct{}{},
+		queue:   make(chan string, 1024),
+		quit:    make(chan struct{}),
+		db:      db,
+	}
+	if db != nil {
+		err := index.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	index.watcher = watcher
+
+	go index.hashFiles()
+	go index.watch()
+	go func() {
+		index.scan(root)
+		log.Println(">>> release directory scan queued")
+	}()
+	return index, nil
+}
+
+// newChecksumCache returns a checksum index that does not watch a release
+// directory, checksums are only recorded through Update
+func newChecksumCache() *ChecksumIndex {
+	return &ChecksumIndex{
+		entries: map[string]indexEntry{},
+	}
+}
+
+// load reads the persisted checksums, checksums of files no longer in the
+// release directory are dropped
+func (index *ChecksumIndex) load() error {
+	return index.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(checksumBucket)
+		if err != nil {
+			return err
+		}
+		removed := [][]byte{}
+		err = bucket.ForEach(func(k, v []byte) error {
+			entry := indexEntry{}
+			_, err := os.Stat(filepath.Join(index.root, filepath.FromSlash(string(k))))
+			if err != nil || json.Unmarshal(v, &entry) != nil {
+				removed = append(removed, append([]byte{}, k...))
+				return nil
+			}
+			index.entries[string(k)] = entry
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range removed {
+			err := bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops watching the release directory
+func (index *ChecksumIndex) Close() error {
+	if index.watcher == nil {
+		return nil
+	}
+	close(index.quit)
+	return index.watcher.Close()
+}
+
+// Lookup fetches the indexed checksum of a file, the checksum is only
+// returned if the file is unchanged since it was hashed
+func (index *ChecksumIndex) Lookup(key string, info *ReleaseInfo, algorithm string) (string, bool) {
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+	entry, ok := index.entries[key]
+	if !ok || !entry.fresh(info) {
+		return "", false
+	}
+	checksum, ok := entry.Checksums[algorithm]
+	return checksum, ok
+}
+
+// Update records the checksum of a file, checksums recorded for an older
+// revision of the file are discarded
+func (index *ChecksumIndex) Update(key string, info *ReleaseInfo, algorithm string, checksum string) {
+	index.mtx.Lock()
+	defer index.mtx.Unlock()
+	entry, ok := index.entries[key]
+	if !ok || !entry.fresh(info) {
+		entry = indexEntry{
+			Size:      info.Size,
+			ModTime:   info.ModTime,
+			Checksums: map[string]string{},
+		}
+		index.entries[key] = entry
+	}
+	entry.Checksums[algorithm] = checksum
+	if index.db == nil {
+		return
+	}
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(checksumBucket).Put([]byte(key), entryBytes)
+	})
+	if err != nil {
+		log.Printf("failed to persist the checksums of %s: %s", key, err)
+	}
+}
+
+// key forms the release key of a path in the release directory
+func (index *ChecksumIndex) key(path string) (string, error) {
+	rel, err := filepath.Rel(index.root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// remove drops a file and any files below it from the index
+func (index *ChecksumIndex) remove(path string) {
+	key, err := index.key(path)
+	if err != nil {
+		return
+	}
+	prefix := key + "/"
+	index.mtx.Lock()
+	defer index.mtx.Unlock()
+	removed := []string{}
+	for entryKey := range index.entries {
+		if entryKey == key || strings.HasPrefix(entryKey, prefix) {
+			delete(index.entries, entryKey)
+			removed = append(removed, entryKey)
+		}
+	}
+	if index.db == nil || len(removed) == 0 {
+		return
+	}
+	err = index.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(checksumBucket)
+		for _, entryKey := range removed {
+			err := bucket.Delete([]byte(entryKey))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to remove the checksums of %s: %s", key, err)
+	}
+}
+
+// enqueue queues a file for hashing if it is not already queued
+func (index *ChecksumIndex) enqueue(path string) {
+	path = filepath.Clean(path)
+	index.mtx.Lock()
+	_, queued := index.pending[path]
+	if !queued {
+		index.pending[path] = struct{}{}
+	}
+	index.mtx.Unlock()
+	if queued {
+		return
+	}
+
+	select {
+	case index.queue <- path:
+	case <-index.quit:
+	}
+}
+
+// scan watches a directory tree and queues its files for hashing
+func (index *ChecksumIndex) scan(root string) {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("failed to scan %s: %s", path, err)
+			return nil
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			err := index.watcher.Add(path)
+			if err != nil {
+				log.Printf("failed to watch %s: %s", path, err)
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			index.enqueue(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to scan %s: %s", root, err)
+	}
+}
+
+// watch handles release directory change notifications
+func (index *ChecksumIndex) watch() {
+	for {
+		select {
+		case event, ok := <-index.watcher.Events:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(filepath.Base(event.Name), ".") {
+				continue
+			}
+
+			switch {
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				index.remove(event.Name)
+			case event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) != 0:
+				info, err := os.Stat(event.Name)
+				if err != nil {
+					continue
+				}
+				if info.IsDir() {
+					go index.scan(event.Name)
+					continue
+				}
+				if info.Mode().IsRegular() {
+					go index.enqueue(event.Name)
+				}
+			}
+
+		case err, ok := <-index.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("release directory watcher error: %s", err)
+
+		case <-index.quit:
+			return
+		}
+	}
+}
+
+// hashFiles hashes queued files and records their checksums
+func (index *ChecksumIndex) hashFiles() {
+	for {
+		select {
+		case path := <-index.queue:
+			index.mtx.Lock()
+			delete(index.pending, path)
+			index.mtx.Unlock()
+
+			err := index.hash(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("failed to index %s: %s", path, err)
+			}
+
+		case <-index.quit:
+			return
+		}
+	}
+}
+
+// hash computes and records the checksum of a file, files modified while
+// being hashed are queued again. Files unchanged since they were hashed are
+// skipped.
+func (index *ChecksumIndex) hash(path string) error {
+	key, err := index.key(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	before, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, ok := index.Lookup(key, fileReleaseInfo(before), DefaultAlgorithm)
+	if ok {
+		return nil
+	}
+	hash, err := NewHasher(DefaultAlgorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+		go index.enqueue(path)
+		return nil
+	}
+	index.Update(key, fileReleaseInfo(after), DefaultAlgorithm, hex.EncodeToString(hash.Sum(nil)))
+	return nil
+}
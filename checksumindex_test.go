@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testIndexedChecksum waits for the checksum index to hash the current
+// content of a release file and returns its checksum
+func testIndexedChecksum(t *testing.T, index *ChecksumIndex, root string, key string, want string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksum, ok := index.Lookup(key, fileReleaseInfo(info), DefaultAlgorithm)
+		if ok && checksum == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got checksum %q of %s, want %s", checksum, key, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testDigest returns the hex encoded sha256 digest of a content
+func testDigest(content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestChecksumIndexLookup(t *testing.T) {
+	index := newChecksumCache()
+	now := time.Now()
+	info := &ReleaseInfo{Size: 10, ModTime: now}
+	index.Update("mounty/1.7/mounty.dmg", info, DefaultAlgorithm, "5ecc")
+
+	tests := []struct {
+		name      string
+		info      *ReleaseInfo
+		algorithm string
+		checksum  string
+	}{
+		{"unchanged file", info, DefaultAlgorithm, "5ecc"},
+		{"other algorithm", info, "sha512", ""},
+		{"resized file", &ReleaseInfo{Size: 11, ModTime: now}, DefaultAlgorithm, ""},
+		{"modified file", &ReleaseInfo{Size: 10, ModTime: now.Add(time.Second)}, DefaultAlgorithm, ""},
+	}
+	for _, test := range tests {
+		checksum, ok := index.Lookup("mounty/1.7/mounty.dmg", test.info, test.algorithm)
+		if checksum != test.checksum || ok != (test.checksum != "") {
+			t.Errorf("%s: got %q, %v, want %q", test.name, checksum, ok, test.checksum)
+		}
+	}
+
+	// checksums of an older revision of the file are discarded
+	modified := &ReleaseInfo{Size: 10, ModTime: now.Add(time.Second)}
+	index.Update("mounty/1.7/mounty.dmg", modified, "sha512", "ba5e")
+	_, ok := index.Lookup("mounty/1.7/mounty.dmg", modified, DefaultAlgorithm)
+	if ok {
+		t.Fatal("checksum of an older revision returned")
+	}
+	_, ok = index.Lookup("mounty/1.7/mounty.dmg", info, DefaultAlgorithm)
+	if ok {
+		t.Fatal("checksum of a replaced revision returned")
+	}
+}
+
+func TestChecksumIndexWatch(t *testing.T) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": testReleaseContent,
+	})
+	defer os.RemoveAll(root)
+	index, err := NewChecksumIndex(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	// the release directory is scanned at startup
+	testIndexedChecksum(t, index, root, "mounty/1.7/mounty.dmg", testDigest(testReleaseContent))
+
+	// modified and added files are hashed again
+	path := filepath.Join(root, "mounty", "1.7", "mounty.dmg")
+	err = ioutil.WriteFile(path, []byte("updated release contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIndexedChecksum(t, index, root, "mounty/1.7/mounty.dmg", testDigest("updated release contents"))
+
+	err = os.MkdirAll(filepath.Join(root, "mounty", "1.8"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "mounty", "1.8", "mounty.dmg"), []byte("new release"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIndexedChecksum(t, index, root, "mounty/1.8/mounty.dmg", testDigest("new release"))
+}
+
+func TestChecksumIndexPersisted(t *testing.T) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": testReleaseContent,
+		"mounty/1.7/mounty.exe": "windows release",
+	})
+	defer os.RemoveAll(root)
+	dir, err := ioutil.TempDir("", "sumd-checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := NewChecksumIndex(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIndexedChecksum(t, index, root, "mounty/1.7/mounty.dmg", testDigest(testReleaseContent))
+	testIndexedChecksum(t, index, root, "mounty/1.7/mounty.exe", testDigest("windows release"))
+	index.Close()
+	db.Close()
+
+	// checksums are available as soon as the index is reopened, files
+	// removed meanwhile are dropped
+	err = os.Remove(filepath.Join(root, "mounty", "1.7", "mounty.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	index, err = NewChecksumIndex(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	info, err := os.Stat(filepath.Join(root, "mounty", "1.7", "mounty.dmg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, ok := index.Lookup("mounty/1.7/mounty.dmg", fileReleaseInfo(info), DefaultAlgorithm)
+	if !ok || checksum != testDigest(testReleaseContent) {
+		t.Fatalf("got persisted checksum %q, %v, want %s", checksum, ok, testDigest(testReleaseContent))
+	}
+	index.mtx.RLock()
+	_, ok = index.entries["mounty/1.7/mounty.exe"]
+	index.mtx.RUnlock()
+	if ok {
+		t.Fatal("checksum of a removed file loaded")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+)
+
+// ErrReleaseTampered is returned when a release file no longer matches the
+// checksum it was verified against
+var ErrReleaseTampered = errors.New("release file does not match its verified checksum")
+
+// verifyingReader hashes a release file while it is streamed, the final
+// read fails if the streamed bytes do not match the verified checksum. Only
+// reads contiguous from the start of the file are hashed.
+type verifyingReader struct {
+	// the release file
+	file ReleaseFile
+	// the running digest
+	hash hash.Hash
+	// the verified checksum
+	checksum string
+	// the release file size
+	size int64
+	// the read offset
+	offset int64
+	// the number of contiguous bytes hashed from the start of the file
+	hashed int64
+	// the verification failure
+	err error
+}
+
+// Constructor
+func newVerifyingReader(file ReleaseFile, algorithm string, checksum string, size int64) (*verifyingReader, error) {
+	hash, err := NewHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{
+		file:     file,
+		hash:     hash,
+		checksum: checksum,
+		size:     size,
+	}, nil
+}
+
+// Read reads from the release file, the bytes completing the file are only
+// returned if the file digest matches the verified checksum
+func (reader *verifyingReader) Read(p []byte) (int, error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	n, err := reader.file.Read(p)
+	if reader.offset != reader.hashed {
+		reader.offset += int64(n)
+		return n, err
+	}
+
+	reader.hash.Write(p[:n])
+	reader.offset += int64(n)
+	reader.hashed += int64(n)
+	if reader.hashed > reader.size {
+		reader.err = ErrReleaseTampered
+		return 0, reader.err
+	}
+	if reader.hashed == reader.size || err == io.EOF {
+		if hex.EncodeToString(reader.hash.Sum(nil)) != reader.checksum {
+			reader.err = ErrReleaseTampered
+			return 0, reader.err
+		}
+	}
+	return n, err
+}
+
+// Seek sets the read offset, seeking to the start of the file restarts
+// the digest
+func (reader *verifyingReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := reader.file.Seek(offset, whence)
+	if err != nil {
+		return position, err
+	}
+	if position == 0 {
+		reader.hash.Reset()
+		reader.hashed = 0
+	}
+	reader.offset = position
+	return position, nil
+}
+
+// verifyRange hashes a release file before a partial range of it is
+// served, partial ranges cannot be verified while streamed. The file is
+// hashed on every range request, its size and modification time can be
+// preserved when it is swapped.
+func (sumd *Sumd) verifyRange(release *CachedRelease, file ReleaseFile) error {
+	checksum, err := sumd.checksum(file, release.Algorithm)
+	if err != nil {
+		return err
+	}
+	if checksum != release.Checksum {
+		return ErrReleaseTampered
+	}
+	return nil
+}
+
+// downloadWriter records the status and the number of body bytes written
+// of a release file response. The download is only started once a release
+// file body is about to be written, responses without one such as not
+// modified or unsatisfiable range responses do not start it.
+type downloadWriter struct {
+	http.ResponseWriter
+	// starts the download, unset if the request does not download the file
+	start func() error
+	// the error the download could not be started with
+	err error
+	// the response status
+	status int
+	// the number of body bytes written
+	written int64
+}
+
+// WriteHeader records the response status and starts the download of
+// responses serving the release file, the response is not written if the
+// download cannot be started
+func (writer *downloadWriter) WriteHeader(status int) {
+	if writer.status != 0 {
+		return
+	}
+	writer.status = status
+	if writer.start != nil && (status == http.StatusOK || status == http.StatusPartialContent) {
+		writer.err = writer.start()
+		if writer.err != nil {
+			return
+		}
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of body bytes written
+func (writer *downloadWriter) Write(p []byte) (int, error) {
+	if writer.status == 0 {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	n, err := writer.ResponseWriter.Write(p)
+	writer.written += int64(n)
+	return n, err
+}
+
+// served returns the start and end byte offsets of the release file bytes
+// written, responses serving no bytes or several ranges report none
+func (writer *downloadWriter) served() (int64, int64, bool) {
+	if writer.written == 0 {
+		return 0, 0, false
+	}
+	switch writer.status {
+	case http.StatusOK:
+		return 0, writer.written, true
+	case http.StatusPartialContent:
+		var start, last, size int64
+		_, err := fmt.Sscanf(writer.Header().Get("Content-Range"), "bytes %d-%d/%d",
+			&start, &last, &size)
+		if err != nil {
+			return 0, 0, false
+		}
+		return start, start + writer.written, true
+	}
+	return 0, 0, false
+}
+
+// alertTamperedRelease reports a release file that changed after it was
+// verified
+func alertTamperedRelease(release *CachedRelease, key string) {
+	log.Printf(">>> ALERT: %s/%s/%s does not match its verified %s checksum %s, download %s aborted",
+		release.Product, release.Version, release.File, release.Algorithm, release.Checksum, key)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrUnsupportedAlgorithm is returned when a checksum algorithm is not
+// registered
+var ErrUnsupportedAlgorithm = errors.New("unsupported checksum algorithm")
+
+// DefaultAlgorithm is the checksum algorithm assumed when a release record
+// does not declare one
+const DefaultAlgorithm = "sha256"
+
+var (
+	// the hasher registry lock
+	hashersMtx sync.RWMutex
+	// the supported checksum algorithms
+	hashers = map[string]func() hash.Hash{
+		"sha256":      sha256.New,
+		"sha512":      sha512.New,
+		"sha3-256":    sha3.New256,
+		"blake2b-256": newBlake2b256,
+	}
+)
+
+// newBlake2b256 returns an unkeyed BLAKE2b-256 hash
+func newBlake2b256() hash.Hash {
+	hash, _ := blake2b.New256(nil)
+	return hash
+}
+
+// RegisterHasher adds a checksum algorithm to the hasher registry
+func RegisterHasher(algorithm string, hasher func() hash.Hash) {
+	hashersMtx.Lock()
+	hashers[algorithm] = hasher
+	hashersMtx.Unlock()
+}
+
+// NewHasher returns a hash for the provided checksum algorithm, the default
+// algorithm is used if none is provided
+func NewHasher(algorithm string) (hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	hashersMtx.RLock()
+	hasher, ok := hashers[algorithm]
+	hashersMtx.RUnlock()
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return hasher(), nil
+}
+
+// SupportedAlgorithms lists the registered checksum algorithms
+func SupportedAlgorithms() []string {
+	hashersMtx.RLock()
+	algorithms := make([]string, 0, len(hashers))
+	for algorithm := range hashers {
+		algorithms = append(algorithms, algorithm)
+	}
+	hashersMtx.RUnlock()
+	sort.Strings(algorithms)
+	return algorithms
+}
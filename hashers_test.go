@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewHasher(t *testing.T) {
+	// digests of "abc" from the published test vectors of each algorithm
+	digests := map[string]string{
+		"sha256":      "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":      "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"sha3-256":    "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+		"blake2b-256": "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		"":            "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+	for _, algorithm := range SupportedAlgorithms() {
+		if _, ok := digests[algorithm]; !ok {
+			t.Errorf("no known digest for supported algorithm %s", algorithm)
+		}
+	}
+	for algorithm, digest := range digests {
+		hasher, err := NewHasher(algorithm)
+		if err != nil {
+			t.Errorf("%q: %v", algorithm, err)
+			continue
+		}
+		hasher.Write([]byte("abc"))
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		if sum != digest {
+			t.Errorf("%q: got digest %s, want %s", algorithm, sum, digest)
+		}
+	}
+}
+
+func TestNewHasherUnsupported(t *testing.T) {
+	for _, algorithm := range []string{"md5", "SHA256", "sha-256"} {
+		hasher, err := NewHasher(algorithm)
+		if err != ErrUnsupportedAlgorithm || hasher != nil {
+			t.Errorf("%s: got %v, %v, want %v", algorithm, hasher, err, ErrUnsupportedAlgorithm)
+		}
+	}
+}
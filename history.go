@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/dnldd/sumd/politeia"
+)
+
+const (
+	// historyFetchLimit is the number of earlier record versions fetched
+	// from politeiad per request
+	historyFetchLimit = 4
+	// historyLoaders is the number of records whose earlier versions are
+	// fetched in the background at once
+	historyLoaders = 2
+)
+
+// RecordHistory describes the versions of a vetted record available to
+// check its checksum metadata for changes
+type RecordHistory struct {
+	// the available record versions, oldest first
+	Records []*v1.Record
+	// the availability of every earlier record version
+	Complete bool
+}
+
+// HistoryLoader fetches the earlier versions of records into the record
+// cache in the background
+type HistoryLoader struct {
+	// the loader lock
+	mtx sync.Mutex
+	// the records being loaded, keyed by token
+	loading map[string]struct{}
+}
+
+// Constructor
+func NewHistoryLoader() *HistoryLoader {
+	return &HistoryLoader{
+		loading: map[string]struct{}{},
+	}
+}
+
+// load fetches versions of a record in the background, the versions are
+// not fetched if the record is already being loaded or historyLoaders
+// records are
+func (loader *HistoryLoader) load(token string, versions []string, fetch func(ctx context.Context, token string, version string) (*v1.Record, error)) {
+	loader.mtx.Lock()
+	defer loader.mtx.Unlock()
+	if _, ok := loader.loading[token]; ok || len(loader.loading) >= historyLoaders {
+		return
+	}
+	loader.loading[token] = struct{}{}
+
+	go func() {
+		defer func() {
+			loader.mtx.Lock()
+			delete(loader.loading, token)
+			loader.mtx.Unlock()
+		}()
+		for _, version := range versions {
+			_, err := fetch(context.Background(), token, version)
+			if err != nil {
+				log.Printf("failed to load record %s version %s: %s", token, version, err)
+				return
+			}
+		}
+	}()
+}
+
+// recordHistory fetches the versions of a vetted record up to the provided
+// latest version, oldest first. Record versions are immutable so earlier
+// versions are served from the record cache once fetched, at most
+// historyFetchLimit other versions are fetched from politeiad, most recent
+// first, and the remaining ones are loaded into the record cache in the
+// background. The history is incomplete if an earlier version is missing,
+// a version that fails verification is an error. A record without a
+// numeric version has no history beyond itself.
+func (sumd *Sumd) recordHistory(ctx context.Context, latest *v1.Record) (*RecordHistory, error) {
+	versions, err := strconv.ParseUint(latest.Version, 10, 64)
+	if err != nil || versions <= 1 {
+		return &RecordHistory{Records: []*v1.Record{latest}, Complete: true}, nil
+	}
+
+	token := latest.CensorshipRecord.Token
+	now := time.Now()
+	records := make([]*v1.Record, 0, versions)
+	missing := []string{}
+	fetched := 0
+	for version := versions - 1; version >= 1; version-- {
+		recordVersion := strconv.FormatUint(version, 10)
+		if sumd.Records != nil {
+			cached, _ := sumd.Records.Get(token, recordVersion, now)
+			if cached != nil {
+				records = append(records, cached)
+				continue
+			}
+		}
+		if fetched >= historyFetchLimit || ctx.Err() != nil {
+			missing = append(missing, recordVersion)
+			continue
+		}
+
+		fetched++
+		record, err := sumd.fetchRecord(ctx, token, recordVersion)
+		if _, ok := err.(*politeia.RecordError); ok {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("failed to fetch record %s version %s: %s", token, recordVersion, err)
+			missing = append(missing, recordVersion)
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(missing) > 0 && sumd.History != nil {
+		sumd.History.load(token, missing, sumd.fetchRecord)
+	}
+
+	history := &RecordHistory{
+		Records:  make([]*v1.Record, 0, len(records)+1),
+		Complete: len(missing) == 0,
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		history.Records = append(history.Records, records[i])
+	}
+	history.Records = append(history.Records, latest)
+	return history, nil
+}
+
+// findRecordVersion returns a version of a record's history
+func findRecordVersion(history []*v1.Record, version string) (*v1.Record, error) {
+	for _, record := range history {
+		if record.Version == version {
+			return record, nil
+		}
+	}
+	return nil, politeia.ErrRecordNotFound
+}
+
+// releaseDeclarations returns the checksum metadata a record declares for the
+// release file of the provided metadata, regardless of the algorithm
+func (sumd *Sumd) releaseDeclarations(record *v1.Record, metadata *ChecksumMetadata) []*ChecksumMetadata {
+	declarations := []*ChecksumMetadata{}
+	for i := range record.Metadata {
+		declaration, err := sumd.Streams.Parse(&record.Metadata[i])
+		if err != nil {
+			continue
+		}
+		if declaration.Product == metadata.Product && declaration.Version == metadata.Version &&
+			declaration.File == metadata.File {
+			declarations = append(declarations, declaration)
+		}
+	}
+	return declarations
+}
+
+// metadataChanges lists the checksum metadata other versions of a record's
+// history declared for a release file that the verified record version does
+// not declare. Release files added by a later version are not changes.
+func (sumd *Sumd) metadataChanges(history []*v1.Record, record *v1.Record, metadata *ChecksumMetadata) []api.MetadataChange {
+	declared := make(map[ChecksumMetadata]bool)
+	for _, declaration := range sumd.releaseDeclarations(record, metadata) {
+		declared[*declaration] = true
+	}
+	changes := []api.MetadataChange{}
+	for _, other := range history {
+		if other.Version == record.Version {
+			continue
+		}
+		for _, declaration := range sumd.releaseDeclarations(other, metadata) {
+			if declared[*declaration] {
+				continue
+			}
+			changes = append(changes, api.MetadataChange{
+				RecordVersion: other.Version,
+				Checksum:      declaration.Checksum,
+				Algorithm:     declaration.Algorithm,
+				Signature:     declaration.Signature,
+				PublicKey:     declaration.PublicKey,
+			})
+		}
+	}
+	return changes
+}
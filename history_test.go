@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"github.com/dnldd/sumd/politeia"
+)
+
+// testHistory sets up a record cache holding the provided versions of a
+// record, politeiad only serves its identity. It returns the number of other
+// requests politeiad received.
+func testHistory(t *testing.T, cached ...int) (func() int, func()) {
+	var mtx sync.Mutex
+	requests := 0
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == v1.IdentityRoute {
+			identityRequest := v1.Identity{}
+			json.NewDecoder(request.Body).Decode(&identityRequest)
+			challenge, _ := hex.DecodeString(identityRequest.Challenge)
+			response := fi.SignMessage(challenge)
+			json.NewEncoder(writer).Encode(v1.IdentityReply{
+				PublicKey: hex.EncodeToString(fi.Public.Key[:]),
+				Response:  hex.EncodeToString(response[:]),
+			})
+			return
+		}
+		mtx.Lock()
+		requests++
+		mtx.Unlock()
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	client := politeia.NewClient(politeia.Config{Host: server.URL, Retries: -1})
+	_, err = client.Identity(context.Background())
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	records, err := NewRecordCache(nil, nil, time.Hour, 0, 100)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	for _, version := range cached {
+		err := records.Put("token", strconv.Itoa(version), testVersion(version), time.Now())
+		if err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+	}
+
+	previous := sumd
+	sumd = &Sumd{
+		Args:     &Args{},
+		Records:  records,
+		Politeia: client,
+	}
+	count := func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return requests
+	}
+	return count, func() {
+		sumd = previous
+		server.Close()
+	}
+}
+
+// testVersion returns a version of the test record
+func testVersion(version int) *v1.Record {
+	return &v1.Record{
+		Status:           v1.RecordStatusPublic,
+		Version:          strconv.Itoa(version),
+		CensorshipRecord: v1.CensorshipRecord{Token: "token"},
+	}
+}
+
+// historyVersions returns the record versions of a history
+func historyVersions(history *RecordHistory) []string {
+	versions := []string{}
+	for _, record := range history.Records {
+		versions = append(versions, record.Version)
+	}
+	return versions
+}
+
+func TestRecordHistoryCached(t *testing.T) {
+	requests, done := testHistory(t, 1, 2)
+	defer done()
+
+	history, err := sumd.recordHistory(context.Background(), testVersion(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !history.Complete || len(history.Records) != 3 {
+		t.Fatalf("got versions %v, complete %v, want a complete history of 3 versions",
+			historyVersions(history), history.Complete)
+	}
+	for i, record := range history.Records {
+		if record.Version != strconv.Itoa(i+1) {
+			t.Fatalf("got versions %v, want oldest first", historyVersions(history))
+		}
+	}
+	if requests() != 0 {
+		t.Fatalf("got %d politeiad requests for a cached history, want 0", requests())
+	}
+}
+
+func TestRecordHistoryUnavailable(t *testing.T) {
+	requests, done := testHistory(t, 1, 2)
+	defer done()
+
+	// only historyFetchLimit missing versions are fetched, failures leave
+	// the history incomplete
+	history, err := sumd.recordHistory(context.Background(), testVersion(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Complete {
+		t.Fatal("history with unavailable versions reported complete")
+	}
+	versions := historyVersions(history)
+	if len(versions) != 3 || versions[0] != "1" || versions[1] != "2" || versions[2] != "10" {
+		t.Fatalf("got versions %v, want the cached versions and the latest", versions)
+	}
+	if requests() != historyFetchLimit {
+		t.Fatalf("got %d politeiad requests, want %d", requests(), historyFetchLimit)
+	}
+
+	// a record without earlier versions has a complete history
+	history, err = sumd.recordHistory(context.Background(), testVersion(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !history.Complete || len(history.Records) != 1 {
+		t.Fatalf("got versions %v, complete %v, want a complete history of 1 version",
+			historyVersions(history), history.Complete)
+	}
+}
+
+func TestHistoryLoader(t *testing.T) {
+	loader := NewHistoryLoader()
+	release := make(chan struct{})
+	var mtx sync.Mutex
+	fetched := map[string][]string{}
+	fetch := func(ctx context.Context, token string, version string) (*v1.Record, error) {
+		<-release
+		mtx.Lock()
+		fetched[token] = append(fetched[token], version)
+		mtx.Unlock()
+		return testVersion(1), nil
+	}
+
+	loader.load("a", []string{"2", "1"}, fetch)
+	// records already being loaded are not loaded again
+	loader.load("a", []string{"2", "1"}, fetch)
+	loader.load("b", []string{"1"}, fetch)
+	// at most historyLoaders records are loaded at once
+	loader.load("c", []string{"1"}, fetch)
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		loader.mtx.Lock()
+		loading := len(loader.loading)
+		loader.mtx.Unlock()
+		if loading == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("records still loading")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(fetched["a"]) != 2 || len(fetched["b"]) != 1 || len(fetched["c"]) != 0 {
+		t.Fatalf("got fetched versions %v, want 2 versions of a and 1 of b", fetched)
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	api "github.com/dnldd/sumd/api/v1"
 )
 
 // WriteErrorCodeResponse convenience func for creating a json error response
-func WriteErrorCodeResponse(writer *http.ResponseWriter, code int, detail string) {
-	errorBody := map[string]interface{}{}
-	errorBody["errors"] = map[string]string{"msg": detail}
-	detailBytes, _ := json.Marshal(errorBody)
+func WriteErrorCodeResponse(writer *http.ResponseWriter, code int, errorCode api.ErrorStatusT, context ...string) {
+	detailBytes, _ := json.Marshal(api.ErrorReply{
+		ErrorCode:    errorCode,
+		ErrorContext: context,
+	})
 	(*writer).Header().Set("Content-Type", "application/json")
 	(*writer).WriteHeader(code)
 	fmt.Fprintln(*writer, string(detailBytes))
@@ -34,8 +38,9 @@ func WriteObject(writer *http.ResponseWriter, code int, detailjson *[]byte) {
 func AddCORSHeaders(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Encoding, Accept-Language, Access-Control-Request-Headers, Access-Control-Request-Method, Connection, Host, Origin, Referer, User-Agent, Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Encoding, Accept-Language, Access-Control-Request-Headers, Access-Control-Request-Method, Connection, Host, Origin, Referer, User-Agent, Authorization, Content-Type, Range, If-Range")
+		w.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Disposition, Content-Length, Content-Range, ETag, Last-Modified")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 		w.Header().Set("Access-Control-Allow-Credentials", "false")
 
@@ -43,6 +48,22 @@ func AddCORSHeaders(fn http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequireBasicAuth rejects requests without the provided basic auth
+// credentials
+func RequireBasicAuth(user string, pass string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestUser, requestPass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(requestUser), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(requestPass), []byte(pass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="sumd"`)
+			WriteErrorCodeResponse(&w, http.StatusUnauthorized, api.ErrorStatusUnauthorized)
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
 // Options is a HundlerFunc wrapper for handling OPTIONS requests
 func Options(w http.ResponseWriter, r *http.Request) {
 }
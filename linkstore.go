@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLinkNotFound is returned when a download link does not exist or has
+// expired
+var ErrLinkNotFound = errors.New("download link not found")
+
+// ErrLinkExhausted is returned when a download link has reached its
+// download limit
+var ErrLinkExhausted = errors.New("download link exhausted")
+
+// ErrResumeRejected is returned when a download link cannot resume a
+// download at the requested offset without counting a new download
+var ErrResumeRejected = errors.New("download cannot be resumed")
+
+// maxResumes is the number of times each counted download of a link can be
+// resumed without counting a new download
+const maxResumes = 4
+
+// LinkStore describes a store of time-bound release download links
+type LinkStore interface {
+	// Put stores a release under the provided key
+	Put(key string, release *CachedRelease) error
+	// Get fetches the unexpired release stored under the provided key
+	Get(key string) (*CachedRelease, error)
+	// Delete removes the release stored under the provided key
+	Delete(key string) error
+	// Consume atomically records a download of the unexpired release stored
+	// under the provided key
+	Consume(key string, now time.Time) (*CachedRelease, error)
+	// Resume atomically records a resumed download of the unexpired release
+	// stored under the provided key, a download can only be resumed at the
+	// offset it was served up to, up to maxResumes times per counted download
+	Resume(key string, now time.Time, offset int64) (*CachedRelease, error)
+	// Advance records that the release stored under the provided key was
+	// served from the start to the end byte offset, only progress contiguous
+	// from the start of the file is recorded
+	Advance(key string, start int64, end int64) error
+	// Sweep removes all releases expired by the provided time and returns
+	// the number of releases removed
+	Sweep(now time.Time) (int, error)
+}
+
+// expired asserts if a cached release has expired by the provided time
+func (release *CachedRelease) expired(now time.Time) bool {
+	return release.Expiry != nil && !now.Before(*release.Expiry)
+}
+
+// exhausted asserts if a cached release has reached its download limit
+func (release *CachedRelease) exhausted() bool {
+	return release.MaxDownloads > 0 && release.Downloads >= release.MaxDownloads
+}
+
+// resumable asserts if a cached release can resume a download at the
+// provided byte offset without counting a new download, only the offset
+// the download was served up to can be resumed
+func (release *CachedRelease) resumable(offset int64) bool {
+	return offset > 0 && offset == release.Served &&
+		release.Resumes < release.Downloads*maxResumes
+}
+
+// advance records that a cached release was served from the start to the
+// end byte offset, a download from the start of the file restarts the
+// served offset and ranges not continuing from it are ignored
+func (release *CachedRelease) advance(start int64, end int64) {
+	if start == 0 || start == release.Served {
+		release.Served = end
+	}
+}
+
+// RemainingDownloads returns the number of downloads left on a cached
+// release, -1 if unlimited
+func (release *CachedRelease) RemainingDownloads() int {
+	if release.MaxDownloads == 0 {
+		return -1
+	}
+	return release.MaxDownloads - release.Downloads
+}
+
+// MemoryLinkStore is an in-memory, concurrency-safe link store
+type MemoryLinkStore struct {
+	// the store lock
+	mtx sync.RWMutex
+	// the cached releases
+	releases map[string]CachedRelease
+}
+
+// Constructor
+func NewMemoryLinkStore() *MemoryLinkStore {
+	return &MemoryLinkStore{
+		releases: map[string]CachedRelease{},
+	}
+}
+
+// Put stores a release under the provided key
+func (store *MemoryLinkStore) Put(key string, release *CachedRelease) error {
+	store.mtx.Lock()
+	store.releases[key] = *release
+	store.mtx.Unlock()
+	return nil
+}
+
+// Get fetches the unexpired release stored under the provided key
+func (store *MemoryLinkStore) Get(key string) (*CachedRelease, error) {
+	store.mtx.RLock()
+	release, ok := store.releases[key]
+	store.mtx.RUnlock()
+	if !ok || release.expired(time.Now()) {
+		return nil, ErrLinkNotFound
+	}
+	return &release, nil
+}
+
+// Delete removes the release stored under the provided key
+func (store *MemoryLinkStore) Delete(key string) error {
+	store.mtx.Lock()
+	delete(store.releases, key)
+	store.mtx.Unlock()
+	return nil
+}
+
+// Consume atomically records a download of the release stored under the
+// provided key
+func (store *MemoryLinkStore) Consume(key string, now time.Time) (*CachedRelease, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	release, ok := store.releases[key]
+	if !ok || release.expired(now) {
+		return nil, ErrLinkNotFound
+	}
+	if release.exhausted() {
+		return nil, ErrLinkExhausted
+	}
+	release.Downloads++
+	store.releases[key] = release
+	return &release, nil
+}
+
+// Resume atomically records a resumed download of the release stored under
+// the provided key
+func (store *MemoryLinkStore) Resume(key string, now time.Time, offset int64) (*CachedRelease, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	release, ok := store.releases[key]
+	if !ok || release.expired(now) {
+		return nil, ErrLinkNotFound
+	}
+	if !release.resumable(offset) {
+		return nil, ErrResumeRejected
+	}
+	release.Resumes++
+	store.releases[key] = release
+	return &release, nil
+}
+
+// Advance records that the release stored under the provided key was served
+// from the start to the end byte offset
+func (store *MemoryLinkStore) Advance(key string, start int64, end int64) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	release, ok := store.releases[key]
+	if !ok {
+		return ErrLinkNotFound
+	}
+	release.advance(start, end)
+	store.releases[key] = release
+	return nil
+}
+
+// Sweep removes all releases expired by the provided time
+func (store *MemoryLinkStore) Sweep(now time.Time) (int, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	removed := 0
+	for key, release := range store.releases {
+		if release.expired(now) {
+			delete(store.releases, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLinkStores runs a test against every link store implementation
+func testLinkStores(t *testing.T, test func(t *testing.T, store LinkStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryLinkStore())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "sumd-links")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		db, err := OpenDB(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		store, err := NewBoltLinkStore(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+}
+
+// testRelease returns a cached release expiring at the provided time
+func testRelease(expiry time.Time, maxDownloads int) *CachedRelease {
+	return &CachedRelease{
+		Product:      "mounty",
+		Version:      "1.7",
+		File:         "mounty.dmg",
+		Checksum:     "5eccbbea3f91c9646a6c9d1462137b68e9a81b8d860b3855011e9fe6dcc3f280",
+		Algorithm:    DefaultAlgorithm,
+		Expiry:       &expiry,
+		MaxDownloads: maxDownloads,
+	}
+}
+
+func TestLinkStorePutGetDelete(t *testing.T) {
+	testLinkStores(t, func(t *testing.T, store LinkStore) {
+		release := testRelease(time.Now().Add(time.Hour), 0)
+		err := store.Put("key", release)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cached, err := store.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached.Checksum != release.Checksum || !cached.Expiry.Equal(*release.Expiry) {
+			t.Fatalf("got %+v, want %+v", cached, release)
+		}
+
+		_, err = store.Get("unknown")
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v, want %v", err, ErrLinkNotFound)
+		}
+
+		err = store.Delete("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Get("key")
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v, want %v", err, ErrLinkNotFound)
+		}
+	})
+}
+
+func TestLinkStoreExpiryBoundary(t *testing.T) {
+	testLinkStores(t, func(t *testing.T, store LinkStore) {
+		expiry := time.Now().Add(time.Hour)
+		err := store.Put("key", testRelease(expiry, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a link is valid until its expiry and expired at it
+		_, err = store.Consume("key", expiry.Add(-time.Nanosecond))
+		if err != nil {
+			t.Fatalf("link expired before its expiry: %v", err)
+		}
+		_, err = store.Consume("key", expiry)
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v at the expiry, want %v", err, ErrLinkNotFound)
+		}
+
+		removed, err := store.Sweep(expiry.Add(-time.Nanosecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 0 {
+			t.Fatalf("swept %d links before their expiry", removed)
+		}
+		removed, err = store.Sweep(expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 1 {
+			t.Fatalf("swept %d links at their expiry, want 1", removed)
+		}
+		_, err = store.Consume("key", expiry.Add(-time.Nanosecond))
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v after the sweep, want %v", err, ErrLinkNotFound)
+		}
+
+		// expired links are not returned before they are swept
+		err = store.Put("expired", testRelease(time.Now().Add(-time.Second), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Get("expired")
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v for an expired link, want %v", err, ErrLinkNotFound)
+		}
+	})
+}
+
+func TestLinkStoreConcurrentConsume(t *testing.T) {
+	testLinkStores(t, func(t *testing.T, store LinkStore) {
+		const maxDownloads = 5
+		const workers = 32
+		err := store.Put("key", testRelease(time.Now().Add(time.Hour), maxDownloads))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mtx sync.Mutex
+		consumed, exhausted := 0, 0
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := store.Consume("key", time.Now())
+				mtx.Lock()
+				defer mtx.Unlock()
+				switch err {
+				case nil:
+					consumed++
+				case ErrLinkExhausted:
+					exhausted++
+				default:
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if consumed != maxDownloads || exhausted != workers-maxDownloads {
+			t.Fatalf("consumed %d and exhausted %d, want %d and %d", consumed,
+				exhausted, maxDownloads, workers-maxDownloads)
+		}
+
+		release, err := store.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if release.RemainingDownloads() != 0 {
+			t.Fatalf("%d downloads remaining, want 0", release.RemainingDownloads())
+		}
+	})
+}
+
+func TestLinkStoreConcurrentAccess(t *testing.T) {
+	testLinkStores(t, func(t *testing.T, store LinkStore) {
+		const workers = 16
+		const links = 20
+		now := time.Now()
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for j := 0; j < links; j++ {
+					key := fmt.Sprintf("%d-%d", worker, j)
+					// every other link is already expired
+					expiry := now.Add(time.Hour)
+					if j%2 == 1 {
+						expiry = now.Add(-time.Hour)
+					}
+					err := store.Put(key, testRelease(expiry, 0))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					_, err = store.Get(key)
+					if j%2 == 0 && err != nil {
+						t.Errorf("get %s: %v", key, err)
+					}
+					_, err = store.Consume(key, now)
+					if j%2 == 0 && err != nil {
+						t.Errorf("consume %s: %v", key, err)
+					}
+					_, err = store.Sweep(now)
+					if err != nil {
+						t.Error(err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		_, err := store.Sweep(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < workers; i++ {
+			for j := 0; j < links; j++ {
+				key := fmt.Sprintf("%d-%d", i, j)
+				release, err := store.Get(key)
+				if j%2 == 1 {
+					if err != ErrLinkNotFound {
+						t.Fatalf("got %v for expired link %s, want %v", err, key, ErrLinkNotFound)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("get %s: %v", key, err)
+				}
+				if release.Downloads != 1 {
+					t.Fatalf("link %s has %d downloads, want 1", key, release.Downloads)
+				}
+			}
+		}
+	})
+}
+
+func TestLinkStoreResume(t *testing.T) {
+	testLinkStores(t, func(t *testing.T, store LinkStore) {
+		expiry := time.Now().Add(time.Hour)
+		err := store.Put("key", testRelease(expiry, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only counted downloads can be resumed
+		_, err = store.Resume("key", time.Now(), 0)
+		if err != ErrResumeRejected {
+			t.Fatalf("got %v resuming an unstarted download, want %v", err, ErrResumeRejected)
+		}
+		_, err = store.Consume("key", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Advance("key", 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ranges not continuing the download are not recorded
+		err = store.Advance("key", 150, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, offset := range []int64{1, 50, 101, 200} {
+			_, err = store.Resume("key", time.Now(), offset)
+			if err != ErrResumeRejected {
+				t.Fatalf("got %v resuming offset %d, want %v", err, offset, ErrResumeRejected)
+			}
+		}
+
+		served := int64(100)
+		for i := 0; i < maxResumes; i++ {
+			release, err := store.Resume("key", time.Now(), served)
+			if err != nil {
+				t.Fatalf("resume %d: %v", i, err)
+			}
+			if release.Resumes != i+1 {
+				t.Fatalf("got %d resumes, want %d", release.Resumes, i+1)
+			}
+			err = store.Advance("key", served, served+10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the resumed offset cannot be replayed
+			_, err = store.Resume("key", time.Now(), served)
+			if err != ErrResumeRejected {
+				t.Fatalf("got %v replaying offset %d, want %v", err, served, ErrResumeRejected)
+			}
+			served += 10
+		}
+		release, err := store.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if release.Served != served {
+			t.Fatalf("got served offset %d, want %d", release.Served, served)
+		}
+		_, err = store.Resume("key", time.Now(), served)
+		if err != ErrResumeRejected {
+			t.Fatalf("got %v after %d resumes, want %v", err, maxResumes, ErrResumeRejected)
+		}
+		_, err = store.Resume("key", expiry, served)
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v resuming an expired link, want %v", err, ErrLinkNotFound)
+		}
+		err = store.Advance("missing", 0, 100)
+		if err != ErrLinkNotFound {
+			t.Fatalf("got %v advancing a missing link, want %v", err, ErrLinkNotFound)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	// the armored detached signature extension
+	armoredSigExt = ".asc"
+	// the binary detached signature extension
+	binarySigExt = ".sig"
+	// the armored keyring extension
+	armoredKeyringExt = ".asc"
+	// the binary keyring extension
+	binaryKeyringExt = ".gpg"
+)
+
+// PGPKeyrings maps products to the keyrings of their trusted publishers
+type PGPKeyrings map[string]openpgp.EntityList
+
+// LoadPGPKeyrings loads the trusted publisher keyrings of a keyring
+// directory, a product's keyring is read from [product].asc if armored or
+// [product].gpg otherwise
+func LoadPGPKeyrings(dir string) (PGPKeyrings, error) {
+	keyrings := PGPKeyrings{}
+	if dir == "" {
+		return keyrings, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != armoredKeyringExt && ext != binaryKeyringExt {
+			continue
+		}
+
+		product := strings.TrimSuffix(entry.Name(), ext)
+		keyring, err := readKeyring(filepath.Join(dir, entry.Name()), ext == armoredKeyringExt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s keyring: %s", product, err)
+		}
+		keyrings[product] = append(keyrings[product], keyring...)
+	}
+	return keyrings, nil
+}
+
+// readKeyring reads a keyring file
+func readKeyring(path string, armored bool) (openpgp.EntityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if armored {
+		return openpgp.ReadArmoredKeyRing(file)
+	}
+	return openpgp.ReadKeyRing(file)
+}
+
+// PGPResult describes the outcome of a detached signature check
+type PGPResult struct {
+	// the detached signature filename
+	Signature string
+	// the hex encoded fingerprint of the signer's primary key
+	Signer string
+	// the keyring availability, signatures are only checked against a
+	// trusted keyring and fail verification if none is configured
+	Checked bool
+	// the verification status
+	Verified bool
+	// the verification failure reason
+	Err error
+}
+
+// verifyPGPSignature checks the detached OpenPGP signature shipped next to
+// a release file against the product's trusted keyring, the signature is
+// checked over the provided release file contents which are only read if a
+// trusted keyring is configured. It returns nil if the release has no
+// detached signature.
+func (sumd *Sumd) verifyPGPSignature(version string, name, releasefile string, file io.Reader) (*PGPResult, error) {
+	for _, ext := range []string{armoredSigExt, binarySigExt} {
+		sigFile, err := sumd.Store.Open(name, version, releasefile+ext)
+		if err == ErrReleaseNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer sigFile.Close()
+
+		result := &PGPResult{Signature: releasefile + ext}
+		keyring, ok := sumd.Keyrings[name]
+		if !ok {
+			result.Err = fmt.Errorf("signature could not be checked, no trusted keyring configured for %s", name)
+			return result, nil
+		}
+		result.Checked = true
+
+		var signer *openpgp.Entity
+		if ext == armoredSigExt {
+			signer, err = openpgp.CheckArmoredDetachedSignature(keyring, file, sigFile)
+		} else {
+			signer, err = openpgp.CheckDetachedSignature(keyring, file, sigFile)
+		}
+		if err != nil {
+			result.Err = err
+			return result, nil
+		}
+
+		result.Verified = true
+		result.Signer = fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+		return result, nil
+	}
+	return nil, nil
+}
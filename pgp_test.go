@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"golang.org/x/crypto/openpgp"
+)
+
+// testPGPEntity generates an OpenPGP key pair
+func testPGPEntity(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@mounty.example", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// testDetachedSignature signs a content with a detached signature, armored
+// if requested
+func testDetachedSignature(t *testing.T, signer *openpgp.Entity, content string, armored bool) string {
+	signature := &bytes.Buffer{}
+	var err error
+	if armored {
+		err = openpgp.ArmoredDetachSign(signature, signer, strings.NewReader(content), nil)
+	} else {
+		err = openpgp.DetachSign(signature, signer, strings.NewReader(content), nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature.String()
+}
+
+// testPGPRelease serves a release directory holding the test release file
+// and the provided files, mounty's keyring trusts the provided entity
+func testPGPRelease(t *testing.T, trusted *openpgp.Entity, files map[string]string) func() {
+	files["mounty/1.7/mounty.dmg"] = testReleaseContent
+	root := testReleaseDir(t, files)
+	previous := sumd
+	sumd = &Sumd{
+		Args:     &Args{},
+		Store:    NewFileReleaseStore(root),
+		Keyrings: PGPKeyrings{"mounty": openpgp.EntityList{trusted}},
+	}
+	return func() {
+		sumd = previous
+		os.RemoveAll(root)
+	}
+}
+
+func TestVerifyPGPSignature(t *testing.T) {
+	trusted := testPGPEntity(t, "publisher")
+	other := testPGPEntity(t, "impostor")
+	fingerprint := fmt.Sprintf("%X", trusted.PrimaryKey.Fingerprint)
+
+	tests := []struct {
+		name      string
+		signature string
+		content   string
+		keyring   bool
+		checked   bool
+		verified  bool
+	}{
+		{"armored signature", "mounty.dmg.asc", testDetachedSignature(t, trusted, testReleaseContent, true),
+			true, true, true},
+		{"binary signature", "mounty.dmg.sig", testDetachedSignature(t, trusted, testReleaseContent, false),
+			true, true, true},
+		{"untrusted signer", "mounty.dmg.asc", testDetachedSignature(t, other, testReleaseContent, true),
+			true, true, false},
+		{"other content", "mounty.dmg.asc", testDetachedSignature(t, trusted, "tampered release", true),
+			true, true, false},
+		{"malformed signature", "mounty.dmg.asc", "not a signature", true, true, false},
+		{"no keyring", "mounty.dmg.asc", testDetachedSignature(t, trusted, testReleaseContent, true),
+			false, false, false},
+	}
+	for _, test := range tests {
+		done := testPGPRelease(t, trusted, map[string]string{
+			"mounty/1.7/" + test.signature: test.content,
+		})
+		if !test.keyring {
+			sumd.Keyrings = PGPKeyrings{}
+		}
+		result, err := sumd.verifyPGPSignature("1.7", "mounty", "mounty.dmg", strings.NewReader(testReleaseContent))
+		done()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if result == nil || result.Signature != test.signature {
+			t.Errorf("%s: got %+v, want a result for %s", test.name, result, test.signature)
+			continue
+		}
+		if result.Checked != test.checked || result.Verified != test.verified {
+			t.Errorf("%s: got checked %v, verified %v, want %v and %v", test.name,
+				result.Checked, result.Verified, test.checked, test.verified)
+		}
+		if test.verified && (result.Signer != fingerprint || result.Err != nil) {
+			t.Errorf("%s: got signer %s, %v, want %s", test.name, result.Signer, result.Err, fingerprint)
+		}
+		if !test.verified && result.Err == nil {
+			t.Errorf("%s: got no failure reason", test.name)
+		}
+	}
+}
+
+func TestVerifyPGPSignatureMissing(t *testing.T) {
+	done := testPGPRelease(t, testPGPEntity(t, "publisher"), map[string]string{})
+	defer done()
+
+	result, err := sumd.verifyPGPSignature("1.7", "mounty", "mounty.dmg", strings.NewReader(testReleaseContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != nil {
+		t.Fatalf("got %+v for a release without a detached signature, want none", result)
+	}
+}
+
+func TestVerifyReleaseUncheckedPGPSignature(t *testing.T) {
+	trusted := testPGPEntity(t, "publisher")
+	done := testPGPRelease(t, trusted, map[string]string{
+		"mounty/1.7/mounty.dmg.asc": testDetachedSignature(t, trusted, testReleaseContent, true),
+	})
+	defer done()
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumd.Fi = fi
+	metadata := &ChecksumMetadata{
+		Checksum:  testDigest(testReleaseContent),
+		Algorithm: DefaultAlgorithm,
+		Product:   "mounty",
+		Version:   "1.7",
+		File:      "mounty.dmg",
+	}
+	record := &v1.Record{Version: "1", CensorshipRecord: v1.CensorshipRecord{Token: "token"}}
+	history := &RecordHistory{Records: []*v1.Record{record}, Complete: true}
+
+	reply, err := sumd.verifyRelease(record, history, metadata, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Verified || !reply.PGPVerified {
+		t.Fatalf("got %+v, want a verified release file", reply)
+	}
+
+	// a signature that cannot be checked fails the verification
+	sumd.Keyrings = PGPKeyrings{}
+	reply, err = sumd.verifyRelease(record, history, metadata, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Verified || reply.PGPVerified || reply.PGPError == "" || reply.Error == nil {
+		t.Fatalf("got %+v, want an unverified release file with an unchecked signature", reply)
+	}
+}
@@ -0,0 +1,428 @@
+package politeia
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"github.com/decred/politeia/util"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a single politeiad request
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetries is the default number of retries of a failed idempotent
+	// request
+	DefaultRetries = 3
+	// DefaultBackoff is the default delay before the first retry
+	DefaultBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the default maximum delay between retries
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+var (
+	ErrNoIdentity        = errors.New("politeiad identity has not been fetched")
+	ErrChallengeMismatch = errors.New("politeiad challenge response verification failed")
+	ErrRecordNotFound    = errors.New("record not found")
+)
+
+// UserError is returned when politeiad rejects a request
+type UserError struct {
+	// the politeiad error code
+	ErrorCode v1.ErrorStatusT
+	// the additional error information
+	ErrorContext []string
+}
+
+// Error satisfies the error interface
+func (e *UserError) Error() string {
+	if len(e.ErrorContext) == 0 {
+		return fmt.Sprintf("politeiad: %s", v1.ErrorStatus[e.ErrorCode])
+	}
+	return fmt.Sprintf("politeiad: %s: %v", v1.ErrorStatus[e.ErrorCode], e.ErrorContext)
+}
+
+// StatusError is returned when politeiad replies with an unexpected status
+type StatusError struct {
+	// the http status code
+	StatusCode int
+	// the politeiad server error code, only set for server errors
+	ServerCode int64
+}
+
+// Error satisfies the error interface
+func (e *StatusError) Error() string {
+	if e.ServerCode != 0 {
+		return fmt.Sprintf("politeiad: %s, server error %d", http.StatusText(e.StatusCode), e.ServerCode)
+	}
+	return fmt.Sprintf("politeiad: %s", http.StatusText(e.StatusCode))
+}
+
+// Temporary asserts if the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// UnavailableError is returned when politeiad could not be reached or kept
+// failing until the retries were exhausted
+type UnavailableError struct {
+	// the last request failure
+	Err error
+}
+
+// Error satisfies the error interface
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("politeiad unavailable: %s", e.Err)
+}
+
+// Config describes a politeiad client
+type Config struct {
+	// the politeiad endpoint
+	Host string
+	// the http client, see NewHTTPClient
+	HTTPClient *http.Client
+	// the pinned politeiad identity, optional
+	Identity *identity.PublicIdentity
+	// the rpc credentials of privileged requests
+	RPCUser string
+	RPCPass string
+	// the timeout of a single request
+	Timeout time.Duration
+	// the number of retries of a failed idempotent request, none if
+	// negative
+	Retries int
+	// the delay before the first retry, doubled for every retry
+	Backoff time.Duration
+	// the maximum delay between retries
+	MaxBackoff time.Duration
+}
+
+// Client is a politeiad client, every reply is checked against the challenge
+// of its request and records are verified against the politeiad identity
+type Client struct {
+	// the client config
+	cfg Config
+	// the politeiad identity lock
+	mtx sync.RWMutex
+	// the politeiad identity
+	identity *identity.PublicIdentity
+}
+
+// Constructor
+func NewClient(cfg Config) *Client {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	switch {
+	case cfg.Retries == 0:
+		cfg.Retries = DefaultRetries
+	case cfg.Retries < 0:
+		cfg.Retries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Client{cfg: cfg}
+}
+
+// PublicIdentity returns the politeiad identity replies are verified against
+func (c *Client) PublicIdentity() (*identity.PublicIdentity, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.identity == nil {
+		return nil, ErrNoIdentity
+	}
+	return c.identity, nil
+}
+
+// backoff returns the delay before a retry
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.cfg.Backoff
+	for i := 0; i < attempt && delay < c.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.cfg.MaxBackoff {
+		delay = c.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// post sends a single request to politeiad, it returns whether a failed
+// request may be retried
+func (c *Client) post(ctx context.Context, route string, auth bool, payload []byte, reply interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", c.cfg.Host+route, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if auth {
+		req.SetBasicAuth(c.cfg.RPCUser, c.cfg.RPCPass)
+	}
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return false, json.Unmarshal(body, reply)
+	case resp.StatusCode == http.StatusBadRequest:
+		userError := &UserError{}
+		err = json.Unmarshal(body, userError)
+		if err != nil {
+			return false, &StatusError{StatusCode: resp.StatusCode}
+		}
+		return false, userError
+	}
+	statusError := &StatusError{StatusCode: resp.StatusCode}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		serverError := v1.ServerErrorReply{}
+		if json.Unmarshal(body, &serverError) == nil {
+			statusError.ServerCode = serverError.ErrorCode
+		}
+	}
+	return statusError.Temporary(), statusError
+}
+
+// do sends a request to politeiad, failed requests are retried with an
+// exponential backoff until the retries are exhausted or the context is
+// done if retry is set. Only idempotent requests may be retried, a request
+// that failed after reaching politeiad may still have been applied. An
+// UnavailableError is returned if no attempt got through.
+func (c *Client) do(ctx context.Context, route string, auth bool, retry bool, request interface{}, reply interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	retries := 0
+	if retry {
+		retries = c.cfg.Retries
+	}
+	for attempt := 0; ; attempt++ {
+		temporary, err := c.post(ctx, route, auth, payload, reply)
+		if err == nil {
+			return nil
+		}
+		if !temporary {
+			return err
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return &UnavailableError{Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return &UnavailableError{Err: err}
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// challenge generates a random request challenge
+func challenge() ([]byte, error) {
+	return util.Random(v1.ChallengeSize)
+}
+
+// verifyResponse asserts the challenge response was signed by politeiad
+func verifyResponse(pid *identity.PublicIdentity, challenge []byte, response string) error {
+	err := util.VerifyChallenge(pid, challenge, response)
+	if err != nil {
+		return ErrChallengeMismatch
+	}
+	return nil
+}
+
+// Identity fetches the politeiad identity and asserts the server holds its
+// private key. The identity must match the pinned identity if one is
+// configured, it is used to verify subsequent replies.
+func (c *Client) Identity(ctx context.Context) (*identity.PublicIdentity, error) {
+	challenge, err := challenge()
+	if err != nil {
+		return nil, err
+	}
+	reply := &v1.IdentityReply{}
+	err = c.do(ctx, v1.IdentityRoute, false, true, v1.Identity{
+		Challenge: hex.EncodeToString(challenge),
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := util.IdentityFromString(reply.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Identity != nil && !bytes.Equal(remote.Key[:], c.cfg.Identity.Key[:]) {
+		return nil, ErrIdentityMismatch
+	}
+	err = verifyResponse(remote, challenge, reply.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.identity = remote
+	c.mtx.Unlock()
+	return remote, nil
+}
+
+// GetVetted fetches a vetted record, the latest version is fetched if no
+// version is provided. The record's censorship record and files are
+// verified and the record must be the requested token and version.
+func (c *Client) GetVetted(ctx context.Context, token string, version string) (*v1.Record, error) {
+	pid, err := c.PublicIdentity()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := challenge()
+	if err != nil {
+		return nil, err
+	}
+	reply := &v1.GetVettedReply{}
+	err = c.do(ctx, v1.GetVettedRoute, false, true, v1.GetVetted{
+		Challenge: hex.EncodeToString(challenge),
+		Token:     token,
+		Version:   version,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyResponse(pid, challenge, reply.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Record.Status == v1.RecordStatusNotFound {
+		return nil, ErrRecordNotFound
+	}
+	err = VerifyRecord(*pid, &reply.Record)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Record.CensorshipRecord.Token != token {
+		return nil, &RecordError{Token: token, Err: ErrTokenMismatch}
+	}
+	if version != "" && reply.Record.Version != version {
+		return nil, &RecordError{Token: token, Err: ErrVersionMismatch}
+	}
+	return &reply.Record, nil
+}
+
+// NewRecord submits a new unvetted record, the returned censorship record is
+// verified to describe the submitted files. Failed submissions are not
+// retried as they may have created the record.
+func (c *Client) NewRecord(ctx context.Context, metadata []v1.MetadataStream, files []v1.File) (*v1.CensorshipRecord, error) {
+	pid, err := c.PublicIdentity()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := challenge()
+	if err != nil {
+		return nil, err
+	}
+	reply := &v1.NewRecordReply{}
+	err = c.do(ctx, v1.NewRecordRoute, false, false, v1.NewRecord{
+		Challenge: hex.EncodeToString(challenge),
+		Metadata:  metadata,
+		Files:     files,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyResponse(pid, challenge, reply.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Verify(*pid, reply.CensorshipRecord, files)
+	if err != nil {
+		return nil, err
+	}
+	return &reply.CensorshipRecord, nil
+}
+
+// SetUnvettedStatus updates the status of an unvetted record, it requires the
+// rpc credentials. Failed updates are not retried.
+func (c *Client) SetUnvettedStatus(ctx context.Context, token string, status v1.RecordStatusT, mdAppend []v1.MetadataStream, mdOverwrite []v1.MetadataStream) error {
+	pid, err := c.PublicIdentity()
+	if err != nil {
+		return err
+	}
+	challenge, err := challenge()
+	if err != nil {
+		return err
+	}
+	reply := &v1.SetUnvettedStatusReply{}
+	err = c.do(ctx, v1.SetUnvettedStatusRoute, true, false, v1.SetUnvettedStatus{
+		Challenge:   hex.EncodeToString(challenge),
+		Token:       token,
+		Status:      status,
+		MDAppend:    mdAppend,
+		MDOverwrite: mdOverwrite,
+	}, reply)
+	if err != nil {
+		return err
+	}
+	return verifyResponse(pid, challenge, reply.Response)
+}
+
+// Inventory fetches the last vetted records and branches, it requires the
+// rpc credentials. The censorship record signature of every record is
+// verified, records including their files are fully verified.
+func (c *Client) Inventory(ctx context.Context, inventory v1.Inventory) (*v1.InventoryReply, error) {
+	pid, err := c.PublicIdentity()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := challenge()
+	if err != nil {
+		return nil, err
+	}
+	inventory.Challenge = hex.EncodeToString(challenge)
+	reply := &v1.InventoryReply{}
+	err = c.do(ctx, v1.InventoryRoute, true, true, inventory, reply)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyResponse(pid, challenge, reply.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, records := range [][]v1.Record{reply.Vetted, reply.Branches} {
+		for i := range records {
+			if inventory.IncludeFiles {
+				err = VerifyRecord(*pid, &records[i])
+			} else {
+				err = VerifyCensorshipRecord(*pid, records[i].CensorshipRecord)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,88 @@
+package politeia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+// testUnavailableClient returns a client of a politeiad that fails every
+// request with a temporary error, along with the number of requests it
+// received per route
+func testUnavailableClient(t *testing.T) (*Client, func(route string) int, func()) {
+	var mtx sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mtx.Lock()
+		requests[request.URL.Path]++
+		mtx.Unlock()
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	fi, err := identity.New()
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	client := NewClient(Config{
+		Host:       server.URL,
+		Retries:    2,
+		Backoff:    time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	})
+	client.identity = &fi.Public
+	count := func(route string) int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return requests[route]
+	}
+	return client, count, server.Close
+}
+
+func TestClientRetries(t *testing.T) {
+	client, count, done := testUnavailableClient(t)
+	defer done()
+	ctx := context.Background()
+
+	tests := []struct {
+		route    string
+		request  func() error
+		attempts int
+	}{
+		{v1.IdentityRoute, func() error {
+			_, err := client.Identity(ctx)
+			return err
+		}, 3},
+		{v1.GetVettedRoute, func() error {
+			_, err := client.GetVetted(ctx, testToken, "")
+			return err
+		}, 3},
+		{v1.InventoryRoute, func() error {
+			_, err := client.Inventory(ctx, v1.Inventory{})
+			return err
+		}, 3},
+		// requests which may have been applied are not retried
+		{v1.NewRecordRoute, func() error {
+			_, err := client.NewRecord(ctx, nil, []v1.File{testFile("index.md", "mounty 1.7")})
+			return err
+		}, 1},
+		{v1.SetUnvettedStatusRoute, func() error {
+			return client.SetUnvettedStatus(ctx, testToken, v1.RecordStatusPublic, nil, nil)
+		}, 1},
+	}
+	for _, test := range tests {
+		err := test.request()
+		if _, ok := err.(*UnavailableError); !ok {
+			t.Errorf("%s: got %v, want an unavailable error", test.route, err)
+		}
+		if attempts := count(test.route); attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.route, attempts, test.attempts)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package politeia
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+var (
+	ErrInvalidSignature  = errors.New("censorship record signature is not a hex encoded ed25519 signature")
+	ErrSignatureMismatch = errors.New("censorship record signature verification failed")
+	ErrNoFiles           = errors.New("record has no files")
+	ErrInvalidPayload    = errors.New("file payload is not base64 encoded")
+	ErrDigestMismatch    = errors.New("file digest does not match its payload")
+	ErrMerkleMismatch    = errors.New("file digests do not match the censorship record merkle root")
+	ErrTokenMismatch     = errors.New("record is not the requested record")
+	ErrVersionMismatch   = errors.New("record is not the requested version")
+)
+
+// RecordError is returned when a politeia record fails verification, Err is
+// one of the record verification errors of this package
+type RecordError struct {
+	// the censorship token
+	Token string
+	// the offending file, only set for file errors
+	File string
+	// the verification failure
+	Err error
+}
+
+// Error satisfies the error interface
+func (e *RecordError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("record %s: %s: %s", e.Token, e.File, e.Err)
+	}
+	return fmt.Sprintf("record %s: %s", e.Token, e.Err)
+}
+
+// VerifyCensorshipRecord ensures that a censorship record is signed by
+// politeiad. The signature covers the hex encoded merkle root of the record
+// files followed by the censorship token.
+func VerifyCensorshipRecord(pid identity.PublicIdentity, csr v1.CensorshipRecord) error {
+	signature, err := hex.DecodeString(csr.Signature)
+	if err != nil || len(signature) != identity.SignatureSize {
+		return &RecordError{Token: csr.Token, Err: ErrInvalidSignature}
+	}
+	var sig [identity.SignatureSize]byte
+	copy(sig[:], signature)
+	if !pid.VerifyMessage([]byte(csr.Merkle+csr.Token), sig) {
+		return &RecordError{Token: csr.Token, Err: ErrSignatureMismatch}
+	}
+	return nil
+}
+
+// Verify ensures that a censorship record is signed by politeiad and
+// describes the provided files
+func Verify(pid identity.PublicIdentity, csr v1.CensorshipRecord, files []v1.File) error {
+	err := VerifyCensorshipRecord(pid, csr)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return &RecordError{Token: csr.Token, Err: ErrNoFiles}
+	}
+	digests := make([]*[sha256.Size]byte, 0, len(files))
+	for _, file := range files {
+		payload, err := base64.StdEncoding.DecodeString(file.Payload)
+		if err != nil {
+			return &RecordError{Token: csr.Token, File: file.Name, Err: ErrInvalidPayload}
+		}
+		digest := sha256.Sum256(payload)
+		if hex.EncodeToString(digest[:]) != file.Digest {
+			return &RecordError{Token: csr.Token, File: file.Name, Err: ErrDigestMismatch}
+		}
+		digests = append(digests, &digest)
+	}
+
+	root := merkle.Root(digests)
+	if root == nil || hex.EncodeToString(root[:]) != csr.Merkle {
+		return &RecordError{Token: csr.Token, Err: ErrMerkleMismatch}
+	}
+	return nil
+}
+
+// VerifyRecord ensures that a record's censorship record is signed by
+// politeiad and describes the record's files
+func VerifyRecord(pid identity.PublicIdentity, record *v1.Record) error {
+	return Verify(pid, record.CensorshipRecord, record.Files)
+}
@@ -0,0 +1,124 @@
+package politeia
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+// the censorship token of test records
+const testToken = "6e1a4fb4cb8a0b8b2c4fd2e0a8e5c1d8b1e8c6e2a1f0b3d9c7e5a4f3b2c1d0e9"
+
+// testFile returns a record file holding the provided payload
+func testFile(name string, payload string) v1.File {
+	digest := sha256.Sum256([]byte(payload))
+	return v1.File{
+		Name:    name,
+		MIME:    "text/plain; charset=utf-8",
+		Digest:  hex.EncodeToString(digest[:]),
+		Payload: base64.StdEncoding.EncodeToString([]byte(payload)),
+	}
+}
+
+// testRecord returns a record holding the provided files, its censorship
+// record signed by the provided identity over the files merkle root and the
+// censorship token
+func testRecord(t *testing.T, fi *identity.FullIdentity, files ...v1.File) *v1.Record {
+	digests := make([]*[sha256.Size]byte, 0, len(files))
+	for _, file := range files {
+		digest, err := hex.DecodeString(file.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d [sha256.Size]byte
+		copy(d[:], digest)
+		digests = append(digests, &d)
+	}
+	root := merkle.Root(digests)
+	merkleRoot := hex.EncodeToString(root[:])
+	signature := fi.SignMessage([]byte(merkleRoot + testToken))
+	return &v1.Record{
+		Status:  v1.RecordStatusPublic,
+		Version: "1",
+		CensorshipRecord: v1.CensorshipRecord{
+			Token:     testToken,
+			Merkle:    merkleRoot,
+			Signature: hex.EncodeToString(signature[:]),
+		},
+		Files: files,
+	}
+}
+
+func TestVerifyRecord(t *testing.T) {
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		pid    identity.PublicIdentity
+		tamper func(record *v1.Record)
+		err    error
+	}{
+		{"valid record", fi.Public, func(record *v1.Record) {}, nil},
+		{"other identity", other.Public, func(record *v1.Record) {}, ErrSignatureMismatch},
+		{"flipped signature", fi.Public, func(record *v1.Record) {
+			signature, _ := hex.DecodeString(record.CensorshipRecord.Signature)
+			signature[0] ^= 0x01
+			record.CensorshipRecord.Signature = hex.EncodeToString(signature)
+		}, ErrSignatureMismatch},
+		{"truncated signature", fi.Public, func(record *v1.Record) {
+			record.CensorshipRecord.Signature = record.CensorshipRecord.Signature[2:]
+		}, ErrInvalidSignature},
+		{"non hex signature", fi.Public, func(record *v1.Record) {
+			record.CensorshipRecord.Signature = "zz" + record.CensorshipRecord.Signature[2:]
+		}, ErrInvalidSignature},
+		{"wrong token", fi.Public, func(record *v1.Record) {
+			record.CensorshipRecord.Token = testToken[1:] + "0"
+		}, ErrSignatureMismatch},
+		{"wrong merkle root", fi.Public, func(record *v1.Record) {
+			// the signed merkle root no longer describes the files
+			record.Files = record.Files[:1]
+		}, ErrMerkleMismatch},
+		{"tampered file payload", fi.Public, func(record *v1.Record) {
+			record.Files[1].Payload = base64.StdEncoding.EncodeToString([]byte("tampered"))
+		}, ErrDigestMismatch},
+		{"invalid file payload", fi.Public, func(record *v1.Record) {
+			record.Files[0].Payload = "not base64!"
+		}, ErrInvalidPayload},
+		{"no files", fi.Public, func(record *v1.Record) {
+			record.Files = nil
+		}, ErrNoFiles},
+	}
+	for _, test := range tests {
+		record := testRecord(t, fi, testFile("index.md", "mounty 1.7"),
+			testFile("checksum.json", `{"checksum":"5ecc"}`))
+		test.tamper(record)
+		err := VerifyRecord(test.pid, record)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%s: got %v, want a verified record", test.name, err)
+			}
+			continue
+		}
+		recordErr, ok := err.(*RecordError)
+		if !ok || recordErr.Err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if recordErr.Token != record.CensorshipRecord.Token {
+			t.Errorf("%s: got an error for record %s, want %s", test.name,
+				recordErr.Token, record.CensorshipRecord.Token)
+		}
+	}
+}
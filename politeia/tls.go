@@ -0,0 +1,74 @@
+// Package politeia provides the politeiad client, trust and record
+// verification primitives shared by sumd and sumdemo.
+package politeia
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidCert        = errors.New("no certificates found in politeiad certificate file")
+	ErrInvalidFingerprint = errors.New("politeiad certificate fingerprint must be a hex encoded sha256 digest")
+	ErrCertMismatch       = errors.New("politeiad certificate does not match the pinned fingerprint")
+	ErrIdentityMismatch   = errors.New("politeiad identity does not match the pinned identity")
+)
+
+// ParseFingerprint decodes a hex encoded sha256 certificate fingerprint,
+// bytes may be separated by colons
+func ParseFingerprint(fingerprint string) ([]byte, error) {
+	digest, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, ErrInvalidFingerprint
+	}
+	return digest, nil
+}
+
+// NewHTTPClient returns a client for politeiad. The server certificate is
+// verified against the CA certificate file if provided and the system roots
+// otherwise. A pinned certificate fingerprint, the sha256 digest of the DER
+// encoded server certificate, replaces chain verification if no CA
+// certificate is provided and is checked in addition to it otherwise.
+func NewHTTPClient(cert string, fingerprint string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if cert != "" {
+		pem, err := ioutil.ReadFile(cert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidCert
+		}
+	}
+	if fingerprint != "" {
+		pinned, err := ParseFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// self signed certificates can only be trusted by their fingerprint
+		tlsConfig.InsecureSkipVerify = cert == ""
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrCertMismatch
+			}
+			digest := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(digest[:], pinned) {
+				return ErrCertMismatch
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
@@ -0,0 +1,149 @@
+package politeia
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+// testTLSServer starts a politeiad serving its identity over tls, it
+// returns the hex encoded fingerprint of its certificate and the path of
+// its PEM encoded certificate
+func testTLSServer(t *testing.T, fi *identity.FullIdentity) (*httptest.Server, string, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		identityRequest := v1.Identity{}
+		json.NewDecoder(request.Body).Decode(&identityRequest)
+		challenge, _ := hex.DecodeString(identityRequest.Challenge)
+		response := fi.SignMessage(challenge)
+		json.NewEncoder(writer).Encode(v1.IdentityReply{
+			PublicKey: hex.EncodeToString(fi.Public.Key[:]),
+			Response:  hex.EncodeToString(response[:]),
+		})
+	}))
+	digest := sha256.Sum256(server.Certificate().Raw)
+
+	cert, err := ioutil.TempFile("", "politeiad-cert")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	defer cert.Close()
+	err = pem.Encode(cert, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err != nil {
+		server.Close()
+		os.Remove(cert.Name())
+		t.Fatal(err)
+	}
+	return server, hex.EncodeToString(digest[:]), cert.Name()
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fingerprint, cert := testTLSServer(t, fi)
+	defer server.Close()
+	defer os.Remove(cert)
+	other := sha256.Sum256([]byte("other certificate"))
+
+	tests := []struct {
+		name        string
+		cert        string
+		fingerprint string
+		err         error
+	}{
+		{"matching fingerprint", "", fingerprint, nil},
+		{"colon separated fingerprint", "", strings.ToUpper(fingerprint[:2] + ":" + fingerprint[2:]), nil},
+		{"mismatched fingerprint", "", hex.EncodeToString(other[:]), ErrCertMismatch},
+		{"ca certificate", cert, "", nil},
+		{"ca certificate and matching fingerprint", cert, fingerprint, nil},
+		{"ca certificate and mismatched fingerprint", cert, hex.EncodeToString(other[:]), ErrCertMismatch},
+	}
+	for _, test := range tests {
+		httpClient, err := NewHTTPClient(test.cert, test.fingerprint)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		client := NewClient(Config{Host: server.URL, HTTPClient: httpClient, Retries: -1})
+		_, err = client.Identity(context.Background())
+		if test.err == nil && err != nil {
+			t.Errorf("%s: got %v, want a verified politeiad", test.name, err)
+		}
+		if test.err != nil && (err == nil || !strings.Contains(err.Error(), test.err.Error())) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// self signed certificates are only trusted by ca or fingerprint
+	httpClient, err := NewHTTPClient("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(Config{Host: server.URL, HTTPClient: httpClient, Retries: -1})
+	_, err = client.Identity(context.Background())
+	if err == nil {
+		t.Fatal("got a verified politeiad with an untrusted certificate")
+	}
+
+	_, err = NewHTTPClient("", "5ecc")
+	if err != ErrInvalidFingerprint {
+		t.Fatalf("got %v, want %v", err, ErrInvalidFingerprint)
+	}
+}
+
+func TestClientIdentityMismatch(t *testing.T) {
+	fi, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fingerprint, cert := testTLSServer(t, fi)
+	defer server.Close()
+	defer os.Remove(cert)
+	httpClient, err := NewHTTPClient("", fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(Config{
+		Host:       server.URL,
+		HTTPClient: httpClient,
+		Identity:   &pinned.Public,
+		Retries:    -1,
+	})
+	_, err = client.Identity(context.Background())
+	if err != ErrIdentityMismatch {
+		t.Fatalf("got %v, want %v", err, ErrIdentityMismatch)
+	}
+	_, err = client.PublicIdentity()
+	if err != ErrNoIdentity {
+		t.Fatalf("got %v, want the mismatched identity unrecorded", err)
+	}
+
+	client = NewClient(Config{
+		Host:       server.URL,
+		HTTPClient: httpClient,
+		Identity:   &fi.Public,
+		Retries:    -1,
+	})
+	_, err = client.Identity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
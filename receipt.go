@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/hex"
+	"time"
+
+	api "github.com/dnldd/sumd/api/v1"
+)
+
+// signReceipt timestamps a verification reply and signs its receipt message
+// with the server identity
+func (sumd *Sumd) signReceipt(reply *api.VerifyReply) {
+	reply.Timestamp = time.Now().Unix()
+	reply.PublicKey = hex.EncodeToString(sumd.Fi.Public.Key[:])
+	signature := sumd.Fi.SignMessage(api.ReceiptMessage(reply))
+	reply.Signature = hex.EncodeToString(signature[:])
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+
+	api "github.com/dnldd/sumd/api/v1"
+)
+
+// inspectRecord reports the integrity of every metadata stream of a vetted
+// record, release files described by checksum metadata are verified
+// concurrently and unrelated streams are reported as such
+func (sumd *Sumd) inspectRecord(ctx context.Context, token string) (*api.RecordReply, error) {
+	record, err := sumd.fetchRecord(ctx, token, "")
+	if err != nil {
+		return nil, err
+	}
+	history, err := sumd.recordHistory(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make([]api.StreamReport, len(record.Metadata))
+	concurrently(len(record.Metadata), func(i int) {
+		stream := &record.Metadata[i]
+		report := &streams[i]
+		report.ID = stream.ID
+
+		metadata, err := sumd.Streams.Parse(stream)
+		if err == ErrUnrelatedStream {
+			return
+		}
+		if err != nil {
+			report.Error = &api.ErrorReply{
+				ErrorCode:    api.ErrorStatusInvalidMetadata,
+				ErrorContext: []string{err.Error()},
+			}
+			return
+		}
+		report.ChecksumMetadata = true
+		report.Product = metadata.Product
+		report.Version = metadata.Version
+		report.File = metadata.File
+		report.Algorithm = metadata.Algorithm
+
+		err = ValidateReleasePath(metadata.Product, metadata.Version, metadata.File)
+		if err == nil {
+			report.Reply, err = sumd.verifyRelease(record, history, metadata, nil)
+		}
+		if err != nil {
+			_, report.Error = errorReply(err)
+		}
+	})
+
+	recordReply := &api.RecordReply{
+		Token:     record.CensorshipRecord.Token,
+		Version:   record.Version,
+		Timestamp: record.Timestamp,
+		Streams:   streams,
+	}
+	recordReply.Verified = recordVerified(streams)
+	return recordReply, nil
+}
+
+// recordVerified asserts if a record is verified from its stream reports,
+// it must hold valid checksum metadata, every release file it describes
+// must be verified and none of its checksum metadata streams may be invalid
+func recordVerified(streams []api.StreamReport) bool {
+	checksumStreams := 0
+	for _, report := range streams {
+		switch {
+		case report.ChecksumMetadata:
+			if report.Reply == nil || !report.Reply.Verified {
+				return false
+			}
+			checksumStreams++
+		case report.Error != nil:
+			// a checksum metadata stream with an invalid payload
+			return false
+		}
+	}
+	return checksumStreams > 0
+}
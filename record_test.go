@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	api "github.com/dnldd/sumd/api/v1"
+)
+
+func TestRecordVerified(t *testing.T) {
+	verified := api.StreamReport{ID: 1, ChecksumMetadata: true, Reply: &api.VerifyReply{Verified: true}}
+	unverified := api.StreamReport{ID: 2, ChecksumMetadata: true, Reply: &api.VerifyReply{}}
+	failed := api.StreamReport{ID: 3, ChecksumMetadata: true, Error: &api.ErrorReply{
+		ErrorCode: api.ErrorStatusReleaseNotFound,
+	}}
+	invalid := api.StreamReport{ID: 4, Error: &api.ErrorReply{
+		ErrorCode: api.ErrorStatusInvalidMetadata,
+	}}
+	unrelated := api.StreamReport{ID: 5}
+
+	tests := []struct {
+		name     string
+		streams  []api.StreamReport
+		verified bool
+	}{
+		{"verified", []api.StreamReport{verified}, true},
+		{"verified with unrelated stream", []api.StreamReport{verified, unrelated}, true},
+		{"no streams", nil, false},
+		{"unrelated streams only", []api.StreamReport{unrelated}, false},
+		{"unverified release file", []api.StreamReport{verified, unverified}, false},
+		{"failed verification", []api.StreamReport{verified, failed}, false},
+		{"invalid checksum metadata", []api.StreamReport{verified, invalid}, false},
+		{"invalid checksum metadata only", []api.StreamReport{invalid}, false},
+	}
+	for _, test := range tests {
+		if got := recordVerified(test.streams); got != test.verified {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.verified)
+		}
+	}
+}
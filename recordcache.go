@@ -0,0 +1,250 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"github.com/dnldd/sumd/politeia"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// the vetted record bucket
+	recordBucket = []byte("records")
+)
+
+// cachedRecord is a vetted record cache entry
+type cachedRecord struct {
+	// the cache key
+	Key string `json:"key"`
+	// the vetted record
+	Record *v1.Record `json:"record"`
+	// the time the record was fetched
+	Fetched time.Time `json:"fetched"`
+}
+
+// RecordCache is a size bounded cache of vetted politeia records, least
+// recently used records are evicted first. Vetted records are immutable per
+// version so records cached for a specific version never expire, the latest
+// version of a record is fresh for the cache ttl and may be served stale
+// while politeiad is unavailable.
+type RecordCache struct {
+	// the cache lock
+	mtx sync.Mutex
+	// the freshness lifetime of latest records
+	ttl time.Duration
+	// the maximum age of stale latest records
+	maxStale time.Duration
+	// the maximum number of cached records
+	size int
+	// the cached records, keyed by record key
+	entries map[string]*list.Element
+	// the records in recency order, most recent first
+	lru *list.List
+	// the database, only set if the cache is persisted
+	db *bolt.DB
+}
+
+// Constructor, persisted records are loaded and verified against the
+// politeiad identity if a database is provided
+func NewRecordCache(db *bolt.DB, pid *identity.PublicIdentity, ttl time.Duration, maxStale time.Duration, size int) (*RecordCache, error) {
+	cache := &RecordCache{
+		ttl:      ttl,
+		maxStale: maxStale,
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		db:       db,
+	}
+	if db == nil {
+		return cache, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(recordBucket)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		invalid := [][]byte{}
+		err = bucket.ForEach(func(k, v []byte) error {
+			entry := &cachedRecord{}
+			if json.Unmarshal(v, entry) != nil || entry.Record == nil ||
+				politeia.VerifyRecord(*pid, entry.Record) != nil ||
+				cache.expired(entry, now) {
+				invalid = append(invalid, append([]byte{}, k...))
+				return nil
+			}
+			cache.add(entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range invalid {
+			err := bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// persisted records beyond the size bound are evicted
+	for cache.lru.Len() > cache.size {
+		err := cache.evict()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
+// recordKey forms the cache key of a record version, the latest version is
+// keyed by the token alone
+func recordKey(token string, version string) string {
+	if version == "" {
+		return token
+	}
+	return token + "/" + version
+}
+
+// expired asserts if a cache entry can no longer be served, even stale
+func (cache *RecordCache) expired(entry *cachedRecord, now time.Time) bool {
+	if entry.Key != entry.Record.CensorshipRecord.Token {
+		return false
+	}
+	return now.Sub(entry.Fetched) > cache.ttl+cache.maxStale
+}
+
+// add inserts an entry as the most recently used record
+func (cache *RecordCache) add(entry *cachedRecord) {
+	element, ok := cache.entries[entry.Key]
+	if ok {
+		element.Value = entry
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[entry.Key] = cache.lru.PushFront(entry)
+}
+
+// evict removes the least recently used record
+func (cache *RecordCache) evict() error {
+	element := cache.lru.Back()
+	if element == nil {
+		return nil
+	}
+	entry := cache.lru.Remove(element).(*cachedRecord)
+	delete(cache.entries, entry.Key)
+	if cache.db == nil {
+		return nil
+	}
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordBucket).Delete([]byte(entry.Key))
+	})
+}
+
+// Get returns a cached record version, the latest version if no version is
+// provided, and whether it is fresh. Stale records are only returned within
+// the maximum staleness.
+func (cache *RecordCache) Get(token string, version string, now time.Time) (*v1.Record, bool) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	element, ok := cache.entries[recordKey(token, version)]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cachedRecord)
+	if cache.expired(entry, now) {
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+	if version != "" {
+		return entry.Record, true
+	}
+	return entry.Record, now.Sub(entry.Fetched) <= cache.ttl
+}
+
+// Put caches a record fetched for a version, the latest version if no
+// version is provided. The latest version is also cached as its specific
+// version.
+func (cache *RecordCache) Put(token string, version string, record *v1.Record, now time.Time) error {
+	entries := []*cachedRecord{{
+		Key:     recordKey(token, version),
+		Record:  record,
+		Fetched: now,
+	}}
+	if version == "" && record.Version != "" {
+		entries = append(entries, &cachedRecord{
+			Key:     recordKey(token, record.Version),
+			Record:  record,
+			Fetched: now,
+		})
+	}
+
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	if cache.db != nil {
+		err := cache.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(recordBucket)
+			for _, entry := range entries {
+				entryBytes, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				err = bucket.Put([]byte(entry.Key), entryBytes)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		cache.add(entry)
+	}
+	for cache.lru.Len() > cache.size {
+		err := cache.evict()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep removes latest records beyond the maximum staleness, it returns
+// the number of records removed
+func (cache *RecordCache) Sweep(now time.Time) (int, error) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	expired := []*list.Element{}
+	for element := cache.lru.Front(); element != nil; element = element.Next() {
+		if cache.expired(element.Value.(*cachedRecord), now) {
+			expired = append(expired, element)
+		}
+	}
+	for _, element := range expired {
+		entry := cache.lru.Remove(element).(*cachedRecord)
+		delete(cache.entries, entry.Key)
+		if cache.db == nil {
+			continue
+		}
+		err := cache.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(recordBucket).Delete([]byte(entry.Key))
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	api "github.com/dnldd/sumd/api/v1"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrConflictingRecords is returned when vetted records declare different
+// checksums for a release file
+var ErrConflictingRecords = errors.New("vetted records declare conflicting checksums")
+
+var (
+	// the release history bucket
+	releaseBucket = []byte("releases")
+	// the release history sync time key
+	syncedKey = []byte("synced")
+)
+
+// ReleaseIndex maps release files to the vetted records declaring their
+// checksums. It is synced with politeiad's inventory and keeps the history
+// of every declaration seen from records still in the inventory, only
+// declarations of the last sync are current.
+type ReleaseIndex struct {
+	// the index lock
+	mtx sync.RWMutex
+	// the checksum declarations, keyed by release key and ordered by
+	// descending record timestamp
+	releases map[string][]api.ReleaseDeclaration
+	// the last sync time
+	synced int64
+	// the checksum metadata stream IDs
+	streams ChecksumStreams
+	// the database, only set if the history is persisted
+	db *bolt.DB
+}
+
+// Constructor, the persisted release history is loaded if a database is
+// provided
+func NewReleaseIndex(db *bolt.DB, streams ChecksumStreams) (*ReleaseIndex, error) {
+	index := &ReleaseIndex{
+		releases: make(map[string][]api.ReleaseDeclaration),
+		streams:  streams,
+		db:       db,
+	}
+	if db == nil {
+		return index, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(releaseBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if string(k) == string(syncedKey) {
+				return json.Unmarshal(v, &index.synced)
+			}
+			declarations := []api.ReleaseDeclaration{}
+			err := json.Unmarshal(v, &declarations)
+			if err != nil {
+				return err
+			}
+			index.releases[string(k)] = declarations
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Sync merges the checksum declarations of the vetted records of an
+// inventory into the release history, unrelated and invalid metadata streams
+// are skipped. Declarations of records that left the inventory are pruned.
+func (index *ReleaseIndex) Sync(records []v1.Record, now time.Time) error {
+	synced := now.Unix()
+
+	index.mtx.Lock()
+	defer index.mtx.Unlock()
+	releases := make(map[string][]api.ReleaseDeclaration, len(index.releases))
+	for key, declarations := range index.releases {
+		releases[key] = append([]api.ReleaseDeclaration{}, declarations...)
+	}
+	inventory := make(map[string]bool, len(records))
+	for i := range records {
+		record := &records[i]
+		inventory[record.CensorshipRecord.Token] = true
+		for j := range record.Metadata {
+			metadata, err := index.streams.Parse(&record.Metadata[j])
+			if err != nil {
+				continue
+			}
+			if ValidateReleasePath(metadata.Product, metadata.Version, metadata.File) != nil {
+				continue
+			}
+			key := releaseKey(metadata.Product, metadata.Version, metadata.File)
+			releases[key] = mergeDeclaration(releases[key], api.ReleaseDeclaration{
+				Token:     record.CensorshipRecord.Token,
+				Timestamp: record.Timestamp,
+				Checksum:  metadata.Checksum,
+				Algorithm: metadata.Algorithm,
+				FirstSeen: synced,
+				LastSeen:  synced,
+			})
+		}
+	}
+	pruned := []string{}
+	for key, declarations := range releases {
+		kept := []api.ReleaseDeclaration{}
+		for _, declaration := range declarations {
+			if inventory[declaration.Token] {
+				kept = append(kept, declaration)
+			}
+		}
+		if len(kept) == 0 {
+			delete(releases, key)
+			pruned = append(pruned, key)
+			continue
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return kept[i].Timestamp > kept[j].Timestamp
+		})
+		releases[key] = kept
+	}
+
+	if index.db != nil {
+		err := index.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(releaseBucket)
+			for _, key := range pruned {
+				err := bucket.Delete([]byte(key))
+				if err != nil {
+					return err
+				}
+			}
+			for key, declarations := range releases {
+				declarationsBytes, err := json.Marshal(declarations)
+				if err != nil {
+					return err
+				}
+				err = bucket.Put([]byte(key), declarationsBytes)
+				if err != nil {
+					return err
+				}
+			}
+			syncedBytes, err := json.Marshal(synced)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(syncedKey, syncedBytes)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	index.releases = releases
+	index.synced = synced
+	return nil
+}
+
+// mergeDeclaration adds a declaration to the history of a release file, a
+// declaration already seen is marked as seen again
+func mergeDeclaration(declarations []api.ReleaseDeclaration, declaration api.ReleaseDeclaration) []api.ReleaseDeclaration {
+	for i := range declarations {
+		seen := &declarations[i]
+		if seen.Token == declaration.Token && seen.Checksum == declaration.Checksum &&
+			seen.Algorithm == declaration.Algorithm {
+			seen.Timestamp = declaration.Timestamp
+			seen.LastSeen = declaration.LastSeen
+			return declarations
+		}
+	}
+	return append(declarations, declaration)
+}
+
+// current returns the declarations of the last sync
+func (index *ReleaseIndex) current(declarations []api.ReleaseDeclaration) []api.ReleaseDeclaration {
+	current := []api.ReleaseDeclaration{}
+	for _, declaration := range declarations {
+		if declaration.LastSeen == index.synced {
+			current = append(current, declaration)
+		}
+	}
+	return current
+}
+
+// Lookup returns the current checksum declarations of a release file, most
+// recent record first
+func (index *ReleaseIndex) Lookup(product string, version string, file string) []api.ReleaseDeclaration {
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+	return index.current(index.releases[releaseKey(product, version, file)])
+}
+
+// Synced returns the last sync time
+func (index *ReleaseIndex) Synced() time.Time {
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+	return time.Unix(index.synced, 0)
+}
+
+// Conflicts lists the release files with current declarations of different
+// checksums for the same algorithm, and the release files declared with the
+// same checksum by several records
+func (index *ReleaseIndex) Conflicts() ([]api.ReleaseConflict, []api.ReleaseConflict) {
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+	conflicts := []api.ReleaseConflict{}
+	duplicates := []api.ReleaseConflict{}
+	keys := make([]string, 0, len(index.releases))
+	for key := range index.releases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		current := index.current(index.releases[key])
+		checksums := make(map[string]string)
+		tokens := make(map[string]int)
+		conflicting, duplicated := false, false
+		for _, declaration := range current {
+			checksum, ok := checksums[declaration.Algorithm]
+			if ok && checksum != declaration.Checksum {
+				conflicting = true
+			}
+			checksums[declaration.Algorithm] = declaration.Checksum
+			tokens[declaration.Algorithm+":"+declaration.Checksum]++
+			if tokens[declaration.Algorithm+":"+declaration.Checksum] > 1 {
+				duplicated = true
+			}
+		}
+		if !conflicting && !duplicated {
+			continue
+		}
+		elem := strings.SplitN(key, "/", 3)
+		conflict := api.ReleaseConflict{
+			Product:      elem[0],
+			Version:      elem[1],
+			File:         elem[2],
+			Declarations: current,
+		}
+		if conflicting {
+			conflicts = append(conflicts, conflict)
+		}
+		if duplicated {
+			duplicates = append(duplicates, conflict)
+		}
+	}
+	return conflicts, duplicates
+}
+
+// syncReleases syncs the release index with politeiad's inventory of vetted
+// records
+func (sumd *Sumd) syncReleases(ctx context.Context) error {
+	inventory, err := sumd.Politeia.Inventory(ctx, v1.Inventory{})
+	if err != nil {
+		return err
+	}
+	err = sumd.Releases.Sync(inventory.Vetted, time.Now())
+	if err != nil {
+		return err
+	}
+	conflicts, _ := sumd.Releases.Conflicts()
+	for _, conflict := range conflicts {
+		log.Printf(">>> ALERT: vetted records declare conflicting checksums for %s/%s/%s",
+			conflict.Product, conflict.Version, conflict.File)
+	}
+	return nil
+}
+
+// resolveToken returns the token of the most recent vetted record declaring
+// a checksum for a release file
+func (sumd *Sumd) resolveToken(product string, version string, file string, algorithm string) (string, error) {
+	for _, declaration := range sumd.Releases.Lookup(product, version, file) {
+		if algorithm == "" || algorithm == declaration.Algorithm {
+			return declaration.Token, nil
+		}
+	}
+	return "", ErrMetadataNotFound
+}
+
+// vouchingTokens returns the tokens of the records declaring the same
+// checksum for a release file as the provided metadata
+func (sumd *Sumd) vouchingTokens(metadata *ChecksumMetadata) []string {
+	tokens := []string{}
+	for _, declaration := range sumd.Releases.Lookup(metadata.Product, metadata.Version, metadata.File) {
+		if declaration.Checksum == metadata.Checksum && declaration.Algorithm == metadata.Algorithm {
+			tokens = append(tokens, declaration.Token)
+		}
+	}
+	return tokens
+}
+
+// conflictingTokens returns the tokens of the other records declaring a
+// different checksum for a release file than the provided metadata, changes
+// within the record itself are reported as metadata changes
+func (sumd *Sumd) conflictingTokens(token string, metadata *ChecksumMetadata) []string {
+	tokens := []string{}
+	for _, declaration := range sumd.Releases.Lookup(metadata.Product, metadata.Version, metadata.File) {
+		if declaration.Token == token {
+			continue
+		}
+		if declaration.Checksum != metadata.Checksum && declaration.Algorithm == metadata.Algorithm {
+			tokens = append(tokens, declaration.Token)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+)
+
+// testReleaseRecord returns a vetted record declaring a checksum for the
+// test release file
+func testReleaseRecord(token string, timestamp int64, checksum string) v1.Record {
+	return v1.Record{
+		Status:           v1.RecordStatusPublic,
+		Timestamp:        timestamp,
+		CensorshipRecord: v1.CensorshipRecord{Token: token},
+		Metadata: []v1.MetadataStream{{
+			ID: 1,
+			Payload: fmt.Sprintf(`{"checksum":"%s","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+				checksum),
+		}},
+	}
+}
+
+// testReleaseIndex syncs a release index with each of the provided
+// inventories in turn, a minute apart
+func testReleaseIndex(t *testing.T, inventories ...[]v1.Record) func() {
+	releases, err := NewReleaseIndex(nil, ChecksumStreams{1: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, inventory := range inventories {
+		err := releases.Sync(inventory, now.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := sumd
+	sumd = &Sumd{
+		Args:     &Args{},
+		Releases: releases,
+	}
+	return func() {
+		sumd = previous
+	}
+}
+
+func TestReleaseIndexConflicts(t *testing.T) {
+	released := testDigest(testReleaseContent)
+	tampered := testDigest("tampered release contents")
+	metadata := &ChecksumMetadata{
+		Checksum:  released,
+		Algorithm: DefaultAlgorithm,
+		Product:   "mounty",
+		Version:   "1.7",
+		File:      "mounty.dmg",
+	}
+
+	tests := []struct {
+		name        string
+		inventories [][]v1.Record
+		conflicts   int
+		duplicates  int
+		token       string
+		conflicting []string
+	}{
+		{"single record", [][]v1.Record{
+			{testReleaseRecord("a", 1, released)},
+		}, 0, 0, "a", []string{}},
+		{"same checksum", [][]v1.Record{
+			{testReleaseRecord("a", 1, released), testReleaseRecord("b", 2, released)},
+		}, 0, 1, "b", []string{}},
+		{"conflicting checksums", [][]v1.Record{
+			{testReleaseRecord("a", 1, released), testReleaseRecord("b", 2, tampered)},
+		}, 1, 0, "b", []string{"b"}},
+		{"conflicting record removed", [][]v1.Record{
+			{testReleaseRecord("a", 1, released), testReleaseRecord("b", 2, tampered)},
+			{testReleaseRecord("a", 1, released)},
+		}, 0, 0, "a", []string{}},
+	}
+	for _, test := range tests {
+		done := testReleaseIndex(t, test.inventories...)
+		conflicts, duplicates := sumd.Releases.Conflicts()
+		token, err := sumd.resolveToken("mounty", "1.7", "mounty.dmg", DefaultAlgorithm)
+		conflicting := sumd.conflictingTokens("a", metadata)
+		done()
+
+		if len(conflicts) != test.conflicts || len(duplicates) != test.duplicates {
+			t.Errorf("%s: got %d conflicts and %d duplicates, want %d and %d", test.name,
+				len(conflicts), len(duplicates), test.conflicts, test.duplicates)
+		}
+		if err != nil || token != test.token {
+			t.Errorf("%s: resolved token %q, %v, want %q", test.name, token, err, test.token)
+		}
+		if !reflect.DeepEqual(conflicting, test.conflicting) {
+			t.Errorf("%s: got conflicting tokens %v, want %v", test.name, conflicting, test.conflicting)
+		}
+	}
+}
+
+func TestResolveTokenAlgorithm(t *testing.T) {
+	done := testReleaseIndex(t, []v1.Record{testReleaseRecord("a", 1, testDigest(testReleaseContent))})
+	defer done()
+
+	_, err := sumd.resolveToken("mounty", "1.7", "mounty.dmg", "sha512")
+	if err != ErrMetadataNotFound {
+		t.Fatalf("got %v for an undeclared algorithm, want %v", err, ErrMetadataNotFound)
+	}
+	_, err = sumd.resolveToken("mounty", "1.8", "mounty.dmg", "")
+	if err != ErrMetadataNotFound {
+		t.Fatalf("got %v for an undeclared release file, want %v", err, ErrMetadataNotFound)
+	}
+}
+
+func TestReleaseIndexPruned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sumd-releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := OpenDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	streams := ChecksumStreams{1: true}
+	releases, err := NewReleaseIndex(db, streams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := testDigest(testReleaseContent)
+	removed := testReleaseRecord("b", 2, testDigest("tampered release contents"))
+	removed.Metadata = append(removed.Metadata, v1.MetadataStream{
+		ID: 1,
+		Payload: fmt.Sprintf(`{"checksum":"%s","product":"mounty","version":"1.8","file":"mounty.dmg"}`,
+			released),
+	})
+	now := time.Now()
+	err = releases.Sync([]v1.Record{testReleaseRecord("a", 1, released), removed}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = releases.Sync([]v1.Record{testReleaseRecord("a", 1, released)}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the declarations of records that left the inventory are pruned from
+	// the persisted history too
+	reopened, err := NewReleaseIndex(db, streams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []*ReleaseIndex{releases, reopened} {
+		if len(index.releases) != 1 {
+			t.Fatalf("got %d release files, want 1", len(index.releases))
+		}
+		declarations := index.releases[releaseKey("mounty", "1.7", "mounty.dmg")]
+		if len(declarations) != 1 || declarations[0].Token != "a" {
+			t.Fatalf("got declarations %+v, want the declaration of a", declarations)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReleasePathError is returned when a product, version or file name cannot
+// be safely resolved within the release store
+type ReleasePathError struct {
+	// the offending field
+	Field string
+	// the offending value
+	Value string
+	// the rejection reason
+	Reason string
+}
+
+// Error describes the invalid release path element
+func (e *ReleasePathError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// validatePathElement asserts a value is a single, non-special path element
+func validatePathElement(field string, value string) error {
+	switch {
+	case value == "":
+		return &ReleasePathError{Field: field, Value: value, Reason: "must not be empty"}
+	case value == "." || value == "..":
+		return &ReleasePathError{Field: field, Value: value, Reason: "must not be a dot segment"}
+	case strings.ContainsAny(value, "/\\"):
+		return &ReleasePathError{Field: field, Value: value, Reason: "must not contain path separators"}
+	case strings.ContainsRune(value, 0):
+		return &ReleasePathError{Field: field, Value: value, Reason: "must not contain NUL bytes"}
+	}
+	return nil
+}
+
+// ValidateReleasePath asserts the product, version and file of a release
+// are safe to splice into a release store path
+func ValidateReleasePath(product string, version string, file string) error {
+	err := validatePathElement("product", product)
+	if err != nil {
+		return err
+	}
+	err = validatePathElement("version", version)
+	if err != nil {
+		return err
+	}
+	return validatePathElement("file", file)
+}
+
+// validateListPath asserts the elements of a release listing path are safe
+// to splice into a release store path
+func validateListPath(path []string) error {
+	fields := []string{"product", "version", "file"}
+	if len(path) > len(fields) {
+		return &ReleasePathError{Field: "path", Value: strings.Join(path, "/"), Reason: "too many path elements"}
+	}
+	for i, elem := range path {
+		err := validatePathElement(fields[i], elem)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveReleasePath resolves validated release path elements to a
+// filesystem path, rejecting symlinks that escape the release directory
+func resolveReleasePath(root string, elem ...string) (string, error) {
+	err := validateListPath(elem)
+	if err != nil {
+		return "", err
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(append([]string{root}, elem...)...))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrReleaseNotFound
+		}
+		return "", err
+	}
+
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &ReleasePathError{
+			Field:  "path",
+			Value:  strings.Join(elem, "/"),
+			Reason: "escapes the release directory",
+		}
+	}
+	return realPath, nil
+}
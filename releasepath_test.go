@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSymlinkReleaseDir creates a release directory next to an outside
+// directory, with symlinks escaping the release directory at the product,
+// version and file levels. It returns the release directory and a cleanup
+// function.
+func testSymlinkReleaseDir(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "sumd-releasepath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "rel")
+	outside := filepath.Join(dir, "outside")
+	for _, path := range []string{
+		filepath.Join(root, "mounty", "1.7"),
+		filepath.Join(outside, "1.7"),
+	} {
+		err := os.MkdirAll(path, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(root, "mounty", "1.7", "mounty.dmg"),
+		filepath.Join(outside, "1.7", "secret"),
+		filepath.Join(outside, "secret"),
+	} {
+		err := ioutil.WriteFile(path, []byte("content"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		// a product escaping the release directory
+		filepath.Join(root, "escape"): outside,
+		// a version escaping the release directory
+		filepath.Join(root, "mounty", "escape"): filepath.Join(outside, "1.7"),
+		// a file escaping the release directory
+		filepath.Join(root, "mounty", "1.7", "escape"): filepath.Join(outside, "secret"),
+		// a file linked within the release directory
+		filepath.Join(root, "mounty", "1.7", "alias.dmg"): filepath.Join(root, "mounty", "1.7", "mounty.dmg"),
+	}
+	for link, target := range links {
+		err := os.Symlink(target, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, func() { os.RemoveAll(dir) }
+}
+
+func TestValidatePathElement(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"name", "mounty", true},
+		{"version", "1.7", true},
+		{"dotted file", "mounty.dmg", true},
+		{"leading dot", ".hidden", true},
+		{"double dot within name", "mounty..dmg", true},
+		{"empty", "", false},
+		{"dot", ".", false},
+		{"dot dot", "..", false},
+		{"slash", "mounty/1.7", false},
+		{"leading slash", "/etc", false},
+		{"trailing slash", "mounty/", false},
+		{"dot dot slash", "../mounty", false},
+		{"backslash", "mounty\\1.7", false},
+		{"dot dot backslash", "..\\mounty", false},
+		{"NUL", "mounty\x00.dmg", false},
+		{"trailing NUL", "mounty\x00", false},
+	}
+	for _, test := range tests {
+		err := validatePathElement("file", test.value)
+		if test.valid && err != nil {
+			t.Errorf("%s: got %v, want valid", test.name, err)
+		}
+		if !test.valid {
+			if _, ok := err.(*ReleasePathError); !ok {
+				t.Errorf("%s: got %v, want a release path error", test.name, err)
+			}
+		}
+	}
+}
+
+func TestResolveReleasePath(t *testing.T) {
+	root, done := testSymlinkReleaseDir(t)
+	defer done()
+
+	tests := []struct {
+		name string
+		elem []string
+		// the expected error, a release path error if escaping
+		err      error
+		escaping bool
+	}{
+		{"release file", []string{"mounty", "1.7", "mounty.dmg"}, nil, false},
+		{"version", []string{"mounty", "1.7"}, nil, false},
+		{"root", nil, nil, false},
+		{"symlink within", []string{"mounty", "1.7", "alias.dmg"}, nil, false},
+		{"missing file", []string{"mounty", "1.7", "missing.dmg"}, ErrReleaseNotFound, false},
+		{"missing product", []string{"missing"}, ErrReleaseNotFound, false},
+		{"dot dot product", []string{"..", "outside", "secret"}, nil, true},
+		{"dot dot version", []string{"mounty", "..", "mounty"}, nil, true},
+		{"dot dot file", []string{"mounty", "1.7", ".."}, nil, true},
+		{"dot version", []string{"mounty", ".", "1.7"}, nil, true},
+		{"separator", []string{"mounty", "1.7/mounty.dmg", "x"}, nil, true},
+		{"backslash", []string{"mounty", "1.7\\..", "x"}, nil, true},
+		{"NUL", []string{"mounty", "1.7", "mounty.dmg\x00"}, nil, true},
+		{"empty", []string{"mounty", "", "mounty.dmg"}, nil, true},
+		{"too many elements", []string{"mounty", "1.7", "mounty.dmg", "x"}, nil, true},
+		{"escaping product", []string{"escape", "1.7", "secret"}, nil, true},
+		{"escaping product listing", []string{"escape"}, nil, true},
+		{"escaping version", []string{"mounty", "escape", "secret"}, nil, true},
+		{"escaping file", []string{"mounty", "1.7", "escape"}, nil, true},
+	}
+	for _, test := range tests {
+		path, err := resolveReleasePath(root, test.elem...)
+		if test.escaping {
+			if _, ok := err.(*ReleasePathError); !ok {
+				t.Errorf("%s: got %q, %v, want a release path error", test.name, path, err)
+			}
+			continue
+		}
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func FuzzResolveReleasePath(f *testing.F) {
+	root, done := testSymlinkReleaseDir(f)
+	defer done()
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add("mounty", "1.7", "mounty.dmg")
+	f.Add("mounty", "1.7", "alias.dmg")
+	f.Add("..", "outside", "secret")
+	f.Add("escape", "1.7", "secret")
+	f.Add("mounty", "escape", "secret")
+	f.Add("mounty", "1.7", "escape")
+	f.Add("mounty", "1.7\\..", "..")
+	f.Add("mounty", "1.7", "mounty.dmg\x00")
+	f.Fuzz(func(t *testing.T, product string, version string, file string) {
+		path, err := resolveReleasePath(root, product, version, file)
+		if err != nil {
+			return
+		}
+		if ValidateReleasePath(product, version, file) != nil {
+			t.Fatalf("resolved invalid release path %q/%q/%q", product, version, file)
+		}
+		rel, err := filepath.Rel(realRoot, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+			filepath.IsAbs(rel) {
+			t.Fatalf("%q/%q/%q resolved to %s outside of %s", product, version, file, path, realRoot)
+		}
+	})
+}
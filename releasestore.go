@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrReleaseNotFound is returned when a release file does not exist in the
+// release store
+var ErrReleaseNotFound = errors.New("release file not found")
+
+// ReleaseFile is an open release file
+type ReleaseFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// ReleaseInfo describes a release store entry
+type ReleaseInfo struct {
+	// the entry name
+	Name string
+	// the entry size in bytes
+	Size int64
+	// the entry modification time
+	ModTime time.Time
+	// the directory flag
+	IsDir bool
+}
+
+// ReleaseStore describes the storage backend of release files laid out as
+// [product]/[version]/[file]
+type ReleaseStore interface {
+	// Open opens a release file for reading
+	Open(product string, version string, file string) (ReleaseFile, error)
+	// Stat describes a release file
+	Stat(product string, version string, file string) (*ReleaseInfo, error)
+	// List describes the entries below a release path, the store root is
+	// listed if no path elements are provided
+	List(path ...string) ([]ReleaseInfo, error)
+}
+
+// releaseKey forms the store independent key of a release file
+func releaseKey(product string, version string, file string) string {
+	return strings.Join([]string{product, version, file}, "/")
+}
+
+// FileReleaseStore is a release store backed by a local release directory
+type FileReleaseStore struct {
+	// the release directory
+	root string
+}
+
+// Constructor
+func NewFileReleaseStore(root string) *FileReleaseStore {
+	return &FileReleaseStore{root: root}
+}
+
+// Open opens a release file for reading
+func (store *FileReleaseStore) Open(product string, version string, file string) (ReleaseFile, error) {
+	path, err := resolveReleasePath(store.root, product, version, file)
+	if err != nil {
+		return nil, err
+	}
+	releaseFile, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, err
+	}
+	return releaseFile, nil
+}
+
+// Stat describes a release file
+func (store *FileReleaseStore) Stat(product string, version string, file string) (*ReleaseInfo, error) {
+	path, err := resolveReleasePath(store.root, product, version, file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrReleaseNotFound
+	}
+	releaseInfo := fileReleaseInfo(info)
+	releaseInfo.Name = file
+	return releaseInfo, nil
+}
+
+// List describes the entries below a release path
+func (store *FileReleaseStore) List(path ...string) ([]ReleaseInfo, error) {
+	dir, err := resolveReleasePath(store.root, path...)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrReleaseNotFound
+		}
+		return nil, err
+	}
+	infos := make([]ReleaseInfo, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		infos = append(infos, *fileReleaseInfo(entry))
+	}
+	return infos, nil
+}
+
+// fileReleaseInfo converts filesystem file info to release info
+func fileReleaseInfo(info os.FileInfo) *ReleaseInfo {
+	return &ReleaseInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testReleaseDir creates a release directory holding the provided files,
+// keyed by their slash separated release path
+func testReleaseDir(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "sumd-releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// releaseInfoNames returns the names and directory flags of release infos
+func releaseInfoNames(infos []ReleaseInfo) map[string]bool {
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.Name] = info.IsDir
+	}
+	return names
+}
+
+func TestFileReleaseStoreOpen(t *testing.T) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": "mounty release",
+	})
+	defer os.RemoveAll(root)
+	store := NewFileReleaseStore(root)
+
+	file, err := store.Open("mounty", "1.7", "mounty.dmg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "mounty release" {
+		t.Fatalf("got %q, want %q", content, "mounty release")
+	}
+
+	_, err = store.Open("mounty", "1.7", "missing.dmg")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("got %v, want %v", err, ErrReleaseNotFound)
+	}
+	_, err = store.Open("mounty", "..", "mounty.dmg")
+	if _, ok := err.(*ReleasePathError); !ok {
+		t.Fatalf("got %v, want a release path error", err)
+	}
+}
+
+func TestFileReleaseStoreStat(t *testing.T) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": "mounty release",
+	})
+	defer os.RemoveAll(root)
+	store := NewFileReleaseStore(root)
+
+	info, err := store.Stat("mounty", "1.7", "mounty.dmg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "mounty.dmg" || info.Size != int64(len("mounty release")) || info.IsDir {
+		t.Fatalf("unexpected release info %+v", info)
+	}
+
+	_, err = store.Stat("mounty", "1.7", "missing.dmg")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("got %v, want %v", err, ErrReleaseNotFound)
+	}
+
+	// directories are not release files
+	err = os.Mkdir(filepath.Join(root, "mounty", "1.7", "dir"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Stat("mounty", "1.7", "dir")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("got %v for a directory, want %v", err, ErrReleaseNotFound)
+	}
+}
+
+func TestFileReleaseStoreList(t *testing.T) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg":     "mounty release",
+		"mounty/1.7/mounty.dmg.asc": "signature",
+		"mounty/1.7/.hidden":        "hidden",
+		"mounty/1.8/mounty.dmg":     "mounty release",
+		"other/2.0/other.tar.gz":    "other release",
+	})
+	defer os.RemoveAll(root)
+	store := NewFileReleaseStore(root)
+
+	tests := []struct {
+		path []string
+		want map[string]bool
+	}{
+		{nil, map[string]bool{"mounty": true, "other": true}},
+		{[]string{"mounty"}, map[string]bool{"1.7": true, "1.8": true}},
+		{[]string{"mounty", "1.7"}, map[string]bool{"mounty.dmg": false, "mounty.dmg.asc": false}},
+	}
+	for _, test := range tests {
+		infos, err := store.List(test.path...)
+		if err != nil {
+			t.Fatalf("list %v: %v", test.path, err)
+		}
+		got := releaseInfoNames(infos)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("list %v: got %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	_, err := store.List("missing")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("got %v, want %v", err, ErrReleaseNotFound)
+	}
+	_, err = store.List("..")
+	if _, ok := err.(*ReleasePathError); !ok {
+		t.Fatalf("got %v, want a release path error", err)
+	}
+}
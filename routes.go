@@ -2,117 +2,439 @@ package main
 
 // CreateRoutes wires up service routes
 import (
-	"einheit/cedindex/service"
-	"einheit/cedindex/utils"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/dnldd/sumd/politeia"
 	"github.com/gorilla/mux"
 )
 
 func CreateRoutes() *mux.Router {
 	router := mux.NewRouter()
 	router.Methods("OPTIONS").Handler(AddCORSHeaders(Options))
-	router.HandleFunc("/verify", service.AddCORSHeaders(VerifyChecksum)).Methods("POST")
-	router.HandleFunc("/download/{key}/{file}", service.AddCORSHeaders(GetReleaseFile)).Methods("GET")
+	router.HandleFunc(api.IdentityRoute, AddCORSHeaders(GetIdentity)).Methods("POST")
+	router.HandleFunc(api.VerifyRoute, AddCORSHeaders(VerifyChecksum)).Methods("POST")
+	router.HandleFunc(api.BatchVerifyRoute, AddCORSHeaders(BatchVerifyChecksum)).Methods("POST")
+	router.HandleFunc(api.RecordRoute, AddCORSHeaders(InspectRecord)).Methods("GET")
+	router.HandleFunc(api.DownloadRoute, AddCORSHeaders(GetReleaseFile)).Methods("GET", "HEAD")
+	router.HandleFunc(api.ProductsRoute, AddCORSHeaders(ListProducts)).Methods("GET")
+	router.HandleFunc(api.VersionsRoute, AddCORSHeaders(ListVersions)).Methods("GET")
+	router.HandleFunc(api.FilesRoute, AddCORSHeaders(ListFiles)).Methods("GET")
+	if sumd.Args.AdminUser != "" && sumd.Releases != nil {
+		router.HandleFunc(api.ConflictsRoute, RequireBasicAuth(sumd.Args.AdminUser, sumd.Args.AdminPass, ListConflicts)).Methods("GET")
+	}
 	return router
 }
 
+// errorReply maps a verification error to its response status and error
+// reply
+func errorReply(err error) (int, *api.ErrorReply) {
+	if _, ok := err.(*ReleasePathError); ok {
+		return http.StatusBadRequest, &api.ErrorReply{
+			ErrorCode:    api.ErrorStatusInvalidReleasePath,
+			ErrorContext: []string{err.Error()},
+		}
+	}
+	if _, ok := err.(*politeia.RecordError); ok {
+		log.Printf("record verification failed: %s", err)
+		return http.StatusBadGateway, &api.ErrorReply{
+			ErrorCode:    api.ErrorStatusInvalidRecord,
+			ErrorContext: []string{err.Error()},
+		}
+	}
+	switch err {
+	case ErrReleaseNotFound:
+		return http.StatusNotFound, &api.ErrorReply{ErrorCode: api.ErrorStatusReleaseNotFound}
+	case ErrConflictingRecords:
+		return http.StatusConflict, &api.ErrorReply{ErrorCode: api.ErrorStatusConflictingRecords}
+	case politeia.ErrRecordNotFound:
+		return http.StatusNotFound, &api.ErrorReply{ErrorCode: api.ErrorStatusRecordNotFound}
+	case ErrMetadataNotFound:
+		return http.StatusNotFound, &api.ErrorReply{ErrorCode: api.ErrorStatusMetadataNotFound}
+	case ErrUnsupportedAlgorithm:
+		return http.StatusBadRequest, &api.ErrorReply{
+			ErrorCode:    api.ErrorStatusUnsupportedAlgorithm,
+			ErrorContext: []string{supportedAlgorithms()},
+		}
+	}
+	log.Printf("verification failed: %s", err)
+	return http.StatusInternalServerError, &api.ErrorReply{
+		ErrorCode:    api.ErrorStatusInternalError,
+		ErrorContext: []string{err.Error()},
+	}
+}
+
+// supportedAlgorithms describes the supported checksum algorithms for the
+// context of unsupported algorithm errors
+func supportedAlgorithms() string {
+	return "supported algorithms: " + strings.Join(SupportedAlgorithms(), ", ")
+}
+
+// verifyErrorResponse writes the error response of a failed verification
+func verifyErrorResponse(writer *http.ResponseWriter, err error) {
+	code, reply := errorReply(err)
+	WriteErrorCodeResponse(writer, code, reply.ErrorCode, reply.ErrorContext...)
+}
+
+// GetIdentity endpoint for the server's public identity, the challenge is
+// signed to prove possession of the identity
+func GetIdentity(writer http.ResponseWriter, request *http.Request) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, api.ErrorStatusInternalError, "failed to read request body")
+		return
+	}
+
+	identityRequest := &api.Identity{}
+	err = json.Unmarshal(body, identityRequest)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRequestPayload, "request body is invalid json")
+		return
+	}
+
+	challenge, err := hex.DecodeString(identityRequest.Challenge)
+	if err != nil || len(challenge) != api.ChallengeSize {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidChallenge)
+		return
+	}
+	response := sumd.Fi.SignMessage(challenge)
+
+	responseJSON, _ := json.Marshal(api.IdentityReply{
+		PublicKey: hex.EncodeToString(sumd.Fi.Public.Key[:]),
+		Response:  hex.EncodeToString(response[:]),
+	})
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
 // VerifyChecksum endpoint for data integrity verification
 func VerifyChecksum(writer http.ResponseWriter, request *http.Request) {
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
-		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, "failed to read request body")
+		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, api.ErrorStatusInternalError, "failed to read request body")
 		return
 	}
 
 	if len(body) == 0 {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "request body is empty")
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRequestPayload, "request body is empty")
 		return
 	}
 
-	data, err := utils.JSONUnmarshal(&body)
+	verifyRequest := &api.VerifyRequest{}
+	err = json.Unmarshal(body, verifyRequest)
 	if err != nil {
-		service.WriteErrorCodeResponse(&writer, http.StatusBadRequest, "request body is invalid json")
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRequestPayload, "request body is invalid json")
 		return
 	}
 
-	token, tokenOk := data["token"].(string)
-	if !tokenOk {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "required 'token' param not found")
+	// the token is resolved from the release index if omitted
+	if verifyRequest.Token == "" && sumd.Releases == nil {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "token")
 		return
 	}
-	product, productOk := data["product"].(string)
-	if !productOk {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "required 'product' param not found")
+	if verifyRequest.Product == "" {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "product")
 		return
 	}
-	version, versionOk := data["version"].(string)
-	if !versionOk {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "required 'version' param not found")
+	if verifyRequest.Version == "" {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "version")
 		return
 	}
-	file, fileOk := data["file"].(string)
-	if !fileOk {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "required 'file' param not found")
+	if verifyRequest.File == "" {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "file")
 		return
 	}
 
-	payload, err := sumd.verify(token, product, version, file)
+	err = ValidateReleasePath(verifyRequest.Product, verifyRequest.Version, verifyRequest.File)
 	if err != nil {
-		if err.Error() == "release file not found" {
-			WriteErrorCodeResponse(&writer, http.StatusBadRequest, err.Error())
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidReleasePath, err.Error())
+		return
+	}
+
+	// optional record version, politeia record versions count up from 1
+	if verifyRequest.RecordVersion != "" {
+		recordVersion, err := strconv.ParseUint(verifyRequest.RecordVersion, 10, 64)
+		if err != nil || recordVersion == 0 {
+			WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRecordVersion, verifyRequest.RecordVersion)
+			return
+		}
+	}
+
+	// optional checksum algorithm
+	if verifyRequest.Algorithm != "" {
+		_, err := NewHasher(verifyRequest.Algorithm)
+		if err != nil {
+			WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusUnsupportedAlgorithm, verifyRequest.Algorithm, supportedAlgorithms())
 			return
 		}
-		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, err.Error())
+	}
+
+	// optional download link limits
+	policy, err := sumd.linkPolicy(verifyRequest.SingleUse, verifyRequest.MaxDownloads, time.Duration(verifyRequest.TTL)*time.Second)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidLinkPolicy, err.Error())
+		return
+	}
+
+	verifyReply, err := sumd.verify(request.Context(), verifyRequest, policy)
+	if err != nil {
+		verifyErrorResponse(&writer, err)
 		return
 	}
 
-	responseJSON, _ := json.Marshal(payload)
+	responseJSON, _ := json.Marshal(verifyReply)
 	WriteObject(&writer, http.StatusOK, &responseJSON)
 	return
 }
 
-// GetReleaseFile start a download for a release file
+// BatchVerifyChecksum endpoint for data integrity verification of several
+// release files of a record
+func BatchVerifyChecksum(writer http.ResponseWriter, request *http.Request) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, api.ErrorStatusInternalError, "failed to read request body")
+		return
+	}
+
+	batchRequest := &api.BatchVerifyRequest{}
+	err = json.Unmarshal(body, batchRequest)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRequestPayload, "request body is invalid json")
+		return
+	}
+
+	if batchRequest.Token == "" {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "token")
+		return
+	}
+	if !batchRequest.All && len(batchRequest.Files) == 0 {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "files")
+		return
+	}
+	if len(batchRequest.Files) > api.BatchVerifyMax {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidRequestPayload,
+			fmt.Sprintf("at most %d files can be verified per batch", api.BatchVerifyMax))
+		return
+	}
+
+	// optional download link limits
+	policy, err := sumd.linkPolicy(batchRequest.SingleUse, batchRequest.MaxDownloads, time.Duration(batchRequest.TTL)*time.Second)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidLinkPolicy, err.Error())
+		return
+	}
+
+	batchReply, err := sumd.verifyBatch(request.Context(), batchRequest, policy)
+	if err != nil {
+		verifyErrorResponse(&writer, err)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(batchReply)
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// InspectRecord endpoint for the integrity report of a record
+func InspectRecord(writer http.ResponseWriter, request *http.Request) {
+	token := mux.Vars(request)["token"]
+	if token == "" {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "token")
+		return
+	}
+
+	recordReply, err := sumd.inspectRecord(request.Context(), token)
+	if err != nil {
+		verifyErrorResponse(&writer, err)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(recordReply)
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// ListProducts endpoint for the products of the release catalog
+func ListProducts(writer http.ResponseWriter, request *http.Request) {
+	productsReply, err := sumd.listProducts()
+	if err != nil {
+		verifyErrorResponse(&writer, err)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(productsReply)
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// ListVersions endpoint for the release versions of a product
+func ListVersions(writer http.ResponseWriter, request *http.Request) {
+	versionsReply, err := sumd.listVersions(mux.Vars(request)["product"])
+	if err != nil {
+		verifyErrorResponse(&writer, err)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(versionsReply)
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// ListFiles endpoint for the release files of a product version, with their
+// checksums and vetted record matches
+func ListFiles(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	filesReply, err := sumd.listFiles(vars["product"], vars["version"])
+	if err != nil {
+		verifyErrorResponse(&writer, err)
+		return
+	}
+
+	responseJSON, _ := json.Marshal(filesReply)
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// ListConflicts admin endpoint for the release files vetted records
+// disagree on
+func ListConflicts(writer http.ResponseWriter, request *http.Request) {
+	conflicts, duplicates := sumd.Releases.Conflicts()
+	responseJSON, _ := json.Marshal(api.ConflictsReply{
+		Synced:     sumd.Releases.Synced().Unix(),
+		Conflicts:  conflicts,
+		Duplicates: duplicates,
+	})
+	WriteObject(&writer, http.StatusOK, &responseJSON)
+}
+
+// downloadOffset returns the byte offset a release file download starts
+// at, requests resuming a download carry a single open or bounded range
+// and a matching If-Range validator if any
+func downloadOffset(request *http.Request, etag string) int64 {
+	rangeHeader := request.Header.Get("Range")
+	if !strings.HasPrefix(rangeHeader, "bytes=") || strings.Contains(rangeHeader, ",") {
+		return 0
+	}
+	// the full file is served if the resumed download is out of date
+	ifRange := request.Header.Get("If-Range")
+	if ifRange != "" && ifRange != etag {
+		return 0
+	}
+	start := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)[0]
+	offset, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// GetReleaseFile start a download for a release file, range requests are
+// supported to resume interrupted downloads. The download is aborted if the
+// release file changed since it was verified, limited links are only
+// consumed by responses serving the file.
 func GetReleaseFile(writer http.ResponseWriter, request *http.Request) {
 	params := mux.Vars(request)
 	key := params["key"]
 	if key == "" {
-		WriteErrorCodeResponse(&writer, http.StatusBadRequest, "required url param 'hash' not found")
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusMissingParam, "key")
 		return
 	}
 
-	payload, ok := (*sumd.Cache)[key]
-
-	if !ok {
-		WriteErrorCodeResponse(&writer, http.StatusNotFound, fmt.Sprintf("%s: release file with supplied key not found"))
+	payload, err := sumd.lookupLink(key, params["file"])
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusNotFound, api.ErrorStatusLinkNotFound, key)
+		return
+	}
+	etag := fmt.Sprintf("%q", payload.Checksum)
+	// exhausted links only serve resumed downloads, the link is only
+	// consumed once the release file is about to be served
+	offset := downloadOffset(request, etag)
+	if payload.exhausted() && !payload.resumable(offset) {
+		WriteErrorCodeResponse(&writer, http.StatusGone, api.ErrorStatusLinkNotFound, key, ErrLinkExhausted.Error())
 		return
 	}
 
-	file, err := sumd.getReleaseFile(payload.Version, payload.Product, payload.File, sumd.Args.ReleaseDir)
+	file, err := sumd.getReleaseFile(payload.Version, payload.Product, payload.File)
+	if _, ok := err.(*ReleasePathError); ok {
+		WriteErrorCodeResponse(&writer, http.StatusBadRequest, api.ErrorStatusInvalidReleasePath, err.Error())
+		return
+	}
 	if err != nil {
-		WriteErrorCodeResponse(&writer, http.StatusNotFound, fmt.Sprintf("%s: file not found"))
+		WriteErrorCodeResponse(&writer, http.StatusNotFound, api.ErrorStatusReleaseNotFound, payload.File)
 		return
 	}
 	defer file.Close()
 
-	stats, _ := file.Stat()
-	// Only the first 512 bytes are used to sniff the content type.
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
+	stats, err := sumd.Store.Stat(payload.Product, payload.Version, payload.File)
+	if err != nil {
+		WriteErrorCodeResponse(&writer, http.StatusNotFound, api.ErrorStatusReleaseNotFound, payload.File)
+		return
+	}
+
+	// the release file is hashed while it is streamed, partial ranges
+	// cannot be and are verified before they are served
+	reader, err := newVerifyingReader(file, payload.Algorithm, payload.Checksum, stats.Size)
 	if err != nil {
-		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, "failed to read file")
+		WriteErrorCodeResponse(&writer, http.StatusInternalServerError, api.ErrorStatusInternalError, err.Error())
 		return
 	}
-	// Reset the read pointer
-	file.Seek(0, 0)
-	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; %s", payload.File))
-	writer.Header().Set("Content-Type", http.DetectContentType(buffer))
-	writer.Header().Set("Content-Length", strconv.Itoa(int(stats.Size())))
-	// stream the file
-	io.Copy(writer, file)
+	rangeHeader := request.Header.Get("Range")
+	if request.Method == http.MethodGet && rangeHeader != "" && rangeHeader != "bytes=0-" {
+		err = sumd.verifyRange(payload, file)
+		if err == ErrReleaseTampered {
+			alertTamperedRelease(payload, key)
+			WriteErrorCodeResponse(&writer, http.StatusConflict, api.ErrorStatusChecksumMismatch, err.Error())
+			return
+		}
+		if err != nil {
+			WriteErrorCodeResponse(&writer, http.StatusInternalServerError, api.ErrorStatusInternalError, err.Error())
+			return
+		}
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": payload.File})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	writer.Header().Set("Content-Disposition", disposition)
+	writer.Header().Set("ETag", etag)
+	// stream the file, serving range, conditional and HEAD requests
+	download := &downloadWriter{ResponseWriter: writer}
+	if request.Method != http.MethodHead {
+		download.start = func() error {
+			_, err := sumd.startDownload(key, payload, offset)
+			return err
+		}
+	}
+	http.ServeContent(download, request, payload.File, stats.ModTime, reader)
+	if download.err != nil {
+		// the download was refused, drop the headers set for the file
+		for _, header := range []string{"Accept-Ranges", "Content-Disposition", "Content-Length",
+			"Content-Range", "ETag", "Last-Modified"} {
+			writer.Header().Del(header)
+		}
+		if download.err == ErrLinkExhausted {
+			WriteErrorCodeResponse(&writer, http.StatusGone, api.ErrorStatusLinkNotFound, key, download.err.Error())
+			return
+		}
+		WriteErrorCodeResponse(&writer, http.StatusNotFound, api.ErrorStatusLinkNotFound, key)
+		return
+	}
+	if reader.err != nil {
+		// abort the connection so the client never receives the final bytes
+		alertTamperedRelease(payload, key)
+		panic(http.ErrAbortHandler)
+	}
+	// record how far the download got so it can only be resumed from the
+	// offset it stopped at
+	start, end, ok := download.served()
+	if payload.MaxDownloads > 0 && ok {
+		err = sumd.Links.Advance(key, start, end)
+		if err != nil && err != ErrLinkNotFound {
+			log.Printf(">>> failed to record download progress of %s: %s", key, err)
+		}
+	}
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/gorilla/mux"
+)
+
+// the release file served by download tests
+const testReleaseContent = "mounty release contents"
+
+// testDownloadRouter serves downloads of a release directory holding the
+// test release file through a memory link store
+func testDownloadRouter(t *testing.T) (*mux.Router, func()) {
+	root := testReleaseDir(t, map[string]string{
+		"mounty/1.7/mounty.dmg": testReleaseContent,
+	})
+	previous := sumd
+	sumd = &Sumd{
+		Args:  &Args{},
+		Links: NewMemoryLinkStore(),
+		Store: NewFileReleaseStore(root),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc(api.DownloadRoute, GetReleaseFile).Methods("GET", "HEAD")
+	return router, func() {
+		sumd = previous
+		os.RemoveAll(root)
+	}
+}
+
+// testDownloadLink stores a download link to the test release file
+func testDownloadLink(t *testing.T, key string, maxDownloads int) {
+	digest := sha256.Sum256([]byte(testReleaseContent))
+	release := testRelease(time.Now().Add(time.Hour), maxDownloads)
+	release.Checksum = hex.EncodeToString(digest[:])
+	err := sumd.Links.Put(key, release)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// download requests a release file download, with a range if set
+func download(router *mux.Router, key string, rangeHeader string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/download/"+key+"/mounty.dmg", nil)
+	if rangeHeader != "" {
+		request.Header.Set("Range", rangeHeader)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// testLinkDownloads asserts the number of downloads recorded for a link
+func testLinkDownloads(t *testing.T, key string, downloads int) {
+	release, err := sumd.Links.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Downloads != downloads {
+		t.Fatalf("got %d downloads of %s, want %d", release.Downloads, key, downloads)
+	}
+}
+
+func TestDownloadOffset(t *testing.T) {
+	etag := `"checksum"`
+	tests := []struct {
+		rangeHeader string
+		ifRange     string
+		offset      int64
+	}{
+		{"", "", 0},
+		{"bytes=0-", "", 0},
+		{"bytes=10-", "", 10},
+		{"bytes=10-19", "", 10},
+		{"bytes=10-", etag, 10},
+		{"bytes=10-", `"stale"`, 0},
+		{"bytes=-10", "", 0},
+		{"bytes=10-19,30-", "", 0},
+		{"items=10-", "", 0},
+		{"bytes=x-", "", 0},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Range", test.rangeHeader)
+		request.Header.Set("If-Range", test.ifRange)
+		offset := downloadOffset(request, etag)
+		if offset != test.offset {
+			t.Errorf("range %q, if-range %q: got offset %d, want %d", test.rangeHeader,
+				test.ifRange, offset, test.offset)
+		}
+	}
+}
+
+// testDownloads requests downloads of a link, in order, with the provided
+// ranges and asserts their response statuses
+func testDownloads(t *testing.T, router *mux.Router, key string, ranges []string, codes []int) {
+	for i, rangeHeader := range ranges {
+		recorder := download(router, key, rangeHeader)
+		if recorder.Code != codes[i] {
+			t.Fatalf("%s range %q: got %d %q, want %d", key, rangeHeader, recorder.Code,
+				recorder.Body.String(), codes[i])
+		}
+	}
+}
+
+func TestGetReleaseFileResume(t *testing.T) {
+	router, done := testDownloadRouter(t)
+	defer done()
+	testDownloadLink(t, "single", 1)
+
+	// the download only served the first 4 bytes
+	recorder := download(router, "single", "bytes=0-3")
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != testReleaseContent[:4] {
+		t.Fatalf("got %d %q, want the first 4 bytes", recorder.Code, recorder.Body.String())
+	}
+	recorder = download(router, "single", "bytes=4-")
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != testReleaseContent[4:] {
+		t.Fatalf("got %d %q, want the resumed release file", recorder.Code, recorder.Body.String())
+	}
+
+	// served offsets cannot be replayed
+	testDownloads(t, router, "single", []string{"bytes=4-", "bytes=1-", ""},
+		[]int{http.StatusGone, http.StatusGone, http.StatusGone})
+
+	// a completed download cannot be resumed
+	testDownloadLink(t, "completed", 1)
+	testDownloads(t, router, "completed", []string{"", "bytes=7-"},
+		[]int{http.StatusOK, http.StatusGone})
+
+	// each counted download is resumed at most maxResumes times
+	testDownloadLink(t, "resumed", 1)
+	ranges := []string{}
+	codes := []int{}
+	for i := 0; i <= maxResumes; i++ {
+		ranges = append(ranges, fmt.Sprintf("bytes=%d-%d", i, i))
+		codes = append(codes, http.StatusPartialContent)
+	}
+	testDownloads(t, router, "resumed", append(ranges, fmt.Sprintf("bytes=%d-", maxResumes+1)),
+		append(codes, http.StatusGone))
+}
+
+func TestGetReleaseFileResumeUnserved(t *testing.T) {
+	router, done := testDownloadRouter(t)
+	defer done()
+
+	// offsets never served by a counted download are new downloads
+	testDownloadLink(t, "unserved", 1)
+	testDownloads(t, router, "unserved", []string{"bytes=0-3", "bytes=10-"},
+		[]int{http.StatusPartialContent, http.StatusGone})
+
+	// a partial request on a fresh link is counted, the bytes before it
+	// were never served so the download cannot be resumed past it
+	testDownloadLink(t, "fresh", 1)
+	testDownloads(t, router, "fresh", []string{"bytes=10-14", "bytes=15-"},
+		[]int{http.StatusPartialContent, http.StatusGone})
+	release, err := sumd.Links.Get("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Downloads != 1 || release.Resumes != 0 || release.Served != 0 {
+		t.Fatalf("got %d downloads, %d resumes served up to %d, want 1 and 0 served up to 0",
+			release.Downloads, release.Resumes, release.Served)
+	}
+}
+
+func TestGetReleaseFileRangeTampered(t *testing.T) {
+	router, done := testDownloadRouter(t)
+	defer done()
+	testDownloadLink(t, "verified", 0)
+	testDownloadLink(t, "tampered", 0)
+
+	recorder := download(router, "verified", "bytes=7-")
+	if recorder.Code != http.StatusPartialContent {
+		t.Fatalf("got %d, want %d", recorder.Code, http.StatusPartialContent)
+	}
+
+	// swap the release file for one of the same size and modification time
+	path := filepath.Join(sumd.Store.(*FileReleaseStore).root, "mounty", "1.7", "mounty.dmg")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(testReleaseContent, "mounty", "evilly", 1)
+	err = ioutil.WriteFile(path, []byte(tampered), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, info.ModTime(), info.ModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// partial ranges are verified against the file content, including on
+	// links whose ranges were verified before the swap
+	for _, key := range []string{"tampered", "verified"} {
+		recorder = download(router, key, "bytes=7-")
+		if recorder.Code != http.StatusConflict {
+			t.Fatalf("%s: got %d %q, want %d", key, recorder.Code, recorder.Body.String(),
+				http.StatusConflict)
+		}
+	}
+	// refused downloads do not consume the link
+	testLinkDownloads(t, "tampered", 0)
+}
+
+func TestGetReleaseFileUnconsumed(t *testing.T) {
+	router, done := testDownloadRouter(t)
+	defer done()
+	testDownloadLink(t, "single", 1)
+
+	// conditional requests answered without the file do not consume the
+	// link
+	digest := sha256.Sum256([]byte(testReleaseContent))
+	request := httptest.NewRequest(http.MethodGet, "/download/single/mounty.dmg", nil)
+	request.Header.Set("If-None-Match", fmt.Sprintf("%q", hex.EncodeToString(digest[:])))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Fatalf("got %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+	testLinkDownloads(t, "single", 0)
+
+	// failed downloads do not consume the link
+	path := filepath.Join(sumd.Store.(*FileReleaseStore).root, "mounty", "1.7", "mounty.dmg")
+	err := os.Rename(path, path+".moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDownloads(t, router, "single", []string{""}, []int{http.StatusNotFound})
+	testLinkDownloads(t, "single", 0)
+	err = os.Rename(path+".moved", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testDownloads(t, router, "single", []string{""}, []int{http.StatusOK})
+	testLinkDownloads(t, "single", 1)
+
+	// exhausted links refuse HEAD requests like downloads
+	request = httptest.NewRequest(http.MethodHead, "/download/single/mounty.dmg", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusGone {
+		t.Fatalf("got %d for a HEAD request, want %d", recorder.Code, http.StatusGone)
+	}
+	testDownloads(t, router, "single", []string{""}, []int{http.StatusGone})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	minio "github.com/minio/minio-go"
+)
+
+// S3ReleaseStore is a release store backed by an S3 compatible object store,
+// release files are stored as [prefix]/[product]/[version]/[file] objects
+type S3ReleaseStore struct {
+	// the object store client
+	client *minio.Client
+	// the release bucket
+	bucket string
+	// the object key prefix
+	prefix string
+}
+
+// Constructor
+func NewS3ReleaseStore(endpoint string, accessKey string, secretKey string, secure bool, bucket string, prefix string) (*S3ReleaseStore, error) {
+	client, err := minio.New(endpoint, accessKey, secretKey, secure)
+	if err != nil {
+		return nil, err
+	}
+	return &S3ReleaseStore{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+// key forms the object key of a release path
+func (store *S3ReleaseStore) key(elem ...string) string {
+	return path.Join(append([]string{store.prefix}, elem...)...)
+}
+
+// storeError maps missing object errors to ErrReleaseNotFound
+func (store *S3ReleaseStore) storeError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return ErrReleaseNotFound
+	}
+	return err
+}
+
+// Open opens a release file for reading
+func (store *S3ReleaseStore) Open(product string, version string, file string) (ReleaseFile, error) {
+	err := ValidateReleasePath(product, version, file)
+	if err != nil {
+		return nil, err
+	}
+	object, err := store.client.GetObject(store.bucket,
+		store.key(product, version, file), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, store.storeError(err)
+	}
+
+	// objects are fetched lazily, stat to assert the object exists
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, store.storeError(err)
+	}
+	return object, nil
+}
+
+// Stat describes a release file
+func (store *S3ReleaseStore) Stat(product string, version string, file string) (*ReleaseInfo, error) {
+	err := ValidateReleasePath(product, version, file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := store.client.StatObject(store.bucket,
+		store.key(product, version, file), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, store.storeError(err)
+	}
+	return &ReleaseInfo{
+		Name:    file,
+		Size:    info.Size,
+		ModTime: info.LastModified,
+	}, nil
+}
+
+// List describes the entries below a release path, common prefixes are
+// listed as directories
+func (store *S3ReleaseStore) List(elem ...string) ([]ReleaseInfo, error) {
+	err := validateListPath(elem)
+	if err != nil {
+		return nil, err
+	}
+	prefix := store.key(elem...)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	infos := []ReleaseInfo{}
+	for object := range store.client.ListObjectsV2(store.bucket, prefix, false, done) {
+		if object.Err != nil {
+			return nil, store.storeError(object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		isDir := strings.HasSuffix(name, "/")
+		name = strings.TrimSuffix(name, "/")
+		if name == "" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		infos = append(infos, ReleaseInfo{
+			Name:    name,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+			IsDir:   isDir,
+		})
+	}
+	if len(infos) == 0 && len(elem) > 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return infos, nil
+}
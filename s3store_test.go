@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-process fake of the S3 object store requests used by the
+// release store, requests are not authenticated
+type fakeS3 struct {
+	// the bucket name
+	bucket string
+	// the objects, keyed by object key
+	objects map[string][]byte
+	// the object modification time
+	modTime time.Time
+}
+
+// fakeS3Error is an S3 error response
+type fakeS3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// fakeS3Object is an S3 object listing entry
+type fakeS3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+// fakeS3Prefix is an S3 common prefix listing entry
+type fakeS3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// fakeS3Listing is an S3 object listing
+type fakeS3Listing struct {
+	XMLName        xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter"`
+	KeyCount       int            `xml:"KeyCount"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []fakeS3Object `xml:"Contents"`
+	CommonPrefixes []fakeS3Prefix `xml:"CommonPrefixes"`
+}
+
+// writeError writes an S3 error response, HEAD responses carry no body
+func (fake *fakeS3) writeError(writer http.ResponseWriter, request *http.Request, status int, code string) {
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(status)
+	if request.Method == http.MethodHead {
+		return
+	}
+	xml.NewEncoder(writer).Encode(fakeS3Error{Code: code, Message: code})
+}
+
+// list writes the listing of the objects below a prefix, keys beyond the
+// delimiter are grouped as common prefixes
+func (fake *fakeS3) list(writer http.ResponseWriter, prefix string, delimiter string) {
+	listing := fakeS3Listing{
+		Name:      fake.bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   1000,
+	}
+	prefixes := map[string]bool{}
+	keys := make([]string, 0, len(fake.objects))
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := prefix + rest[:i+len(delimiter)]
+			if !prefixes[commonPrefix] {
+				prefixes[commonPrefix] = true
+				listing.CommonPrefixes = append(listing.CommonPrefixes, fakeS3Prefix{Prefix: commonPrefix})
+			}
+			continue
+		}
+		listing.Contents = append(listing.Contents, fakeS3Object{
+			Key:          key,
+			LastModified: fake.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"etag"`,
+			Size:         len(fake.objects[key]),
+		})
+	}
+	listing.KeyCount = len(listing.Contents) + len(listing.CommonPrefixes)
+	writer.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(writer).Encode(listing)
+}
+
+// ServeHTTP serves bucket location, object listing, stat and get requests
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	elem := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 2)
+	if elem[0] != fake.bucket {
+		fake.writeError(writer, request, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if len(elem) == 1 || elem[1] == "" {
+		query := request.URL.Query()
+		if _, ok := query["location"]; ok {
+			writer.Header().Set("Content-Type", "application/xml")
+			writer.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`))
+			return
+		}
+		fake.list(writer, query.Get("prefix"), query.Get("delimiter"))
+		return
+	}
+
+	object, ok := fake.objects[elem[1]]
+	if !ok {
+		fake.writeError(writer, request, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	writer.Header().Set("ETag", `"etag"`)
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(writer, request, elem[1], fake.modTime, bytes.NewReader(object))
+}
+
+// testS3Store returns a release store backed by a fake object store holding
+// the provided objects
+func testS3Store(t *testing.T, prefix string, objects map[string][]byte) (*S3ReleaseStore, func()) {
+	fake := &fakeS3{
+		bucket:  "releases",
+		objects: objects,
+		modTime: time.Now().Truncate(time.Second),
+	}
+	server := httptest.NewServer(fake)
+	store, err := NewS3ReleaseStore(strings.TrimPrefix(server.URL, "http://"),
+		"access", "secret", false, "releases", prefix)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return store, server.Close
+}
+
+func TestS3ReleaseStoreOpenStat(t *testing.T) {
+	store, done := testS3Store(t, "/pre/", map[string][]byte{
+		"pre/mounty/1.7/mounty.dmg": []byte("mounty release"),
+	})
+	defer done()
+
+	file, err := store.Open("mounty", "1.7", "mounty.dmg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "mounty release" {
+		t.Fatalf("got %q, want %q", content, "mounty release")
+	}
+
+	info, err := store.Stat("mounty", "1.7", "mounty.dmg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "mounty.dmg" || info.Size != int64(len("mounty release")) {
+		t.Fatalf("unexpected release info %+v", info)
+	}
+
+	// missing objects are mapped to ErrReleaseNotFound
+	_, err = store.Open("mounty", "1.7", "missing.dmg")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("open: got %v, want %v", err, ErrReleaseNotFound)
+	}
+	_, err = store.Stat("mounty", "1.7", "missing.dmg")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("stat: got %v, want %v", err, ErrReleaseNotFound)
+	}
+	_, err = store.Open("mounty", "..", "mounty.dmg")
+	if _, ok := err.(*ReleasePathError); !ok {
+		t.Fatalf("got %v, want a release path error", err)
+	}
+}
+
+func TestS3ReleaseStoreList(t *testing.T) {
+	store, done := testS3Store(t, "pre", map[string][]byte{
+		"pre/mounty/1.7/mounty.dmg":     []byte("mounty release"),
+		"pre/mounty/1.7/mounty.dmg.asc": []byte("signature"),
+		"pre/mounty/1.7/.hidden":        []byte("hidden"),
+		"pre/mounty/1.8/mounty.dmg":     []byte("mounty release"),
+		"pre/other/2.0/other.tar.gz":    []byte("other release"),
+		"outside/mounty/1.7/mounty.dmg": []byte("outside the prefix"),
+	})
+	defer done()
+
+	tests := []struct {
+		path []string
+		want map[string]bool
+	}{
+		{nil, map[string]bool{"mounty": true, "other": true}},
+		{[]string{"mounty"}, map[string]bool{"1.7": true, "1.8": true}},
+		{[]string{"mounty", "1.7"}, map[string]bool{"mounty.dmg": false, "mounty.dmg.asc": false}},
+	}
+	for _, test := range tests {
+		infos, err := store.List(test.path...)
+		if err != nil {
+			t.Fatalf("list %v: %v", test.path, err)
+		}
+		got := releaseInfoNames(infos)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("list %v: got %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	_, err := store.List("missing")
+	if err != ErrReleaseNotFound {
+		t.Fatalf("got %v, want %v", err, ErrReleaseNotFound)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 )
@@ -22,6 +27,30 @@ func main() {
 		panic(err)
 	}
 
+	// stop serving on interrupt, in flight requests are given 30 seconds
+	// to complete
+	server := &http.Server{Addr: sumd.Args.Port, Handler: CreateRoutes()}
+	stopped := make(chan struct{})
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		log.Println(">>> stopping sumd")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Printf("failed to stop serving: %s", err)
+		}
+		close(stopped)
+	}()
+
 	log.Println(">>> started sumd on", sumd.Args.Port)
-	log.Fatal(http.ListenAndServe(sumd.Args.Port, CreateRoutes()))
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	sumd.Close()
+	log.Println(">>> sumd stopped")
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+// ErrInvalidLink is returned when a signed download link fails validation
+var ErrInvalidLink = errors.New("invalid download link")
+
+// signedLinkSeparator separates the release payload and signature of a
+// signed download link
+const signedLinkSeparator = "."
+
+// linkSecret returns the configured link signing secret or derives one from
+// the server's identity
+func linkSecret(args *Args, fi *identity.FullIdentity) ([]byte, error) {
+	if args.LinkSecret != "" {
+		secret, err := hex.DecodeString(args.LinkSecret)
+		if err != nil {
+			return nil, errors.New("link secret must be hex encoded")
+		}
+		return secret, nil
+	}
+	hash := sha256.New()
+	hash.Write([]byte("sumd download link"))
+	hash.Write(fi.PrivateKey[:])
+	return hash.Sum(nil), nil
+}
+
+// signature generates the HMAC of a signed link payload
+func (sumd *Sumd) signature(payload string) []byte {
+	mac := hmac.New(sha256.New, sumd.LinkSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// signLink generates a self describing download key for a release, it
+// encodes the release details and expiry along with their HMAC
+func (sumd *Sumd) signLink(release *CachedRelease) (string, error) {
+	releaseBytes, err := json.Marshal(release)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(releaseBytes)
+	sig := base64.RawURLEncoding.EncodeToString(sumd.signature(payload))
+	return payload + signedLinkSeparator + sig, nil
+}
+
+// verifySignedLink validates a signed download key and returns the release
+// it describes
+func (sumd *Sumd) verifySignedLink(key string) (*CachedRelease, error) {
+	parts := strings.Split(key, signedLinkSeparator)
+	if len(parts) != 2 {
+		return nil, ErrInvalidLink
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	if !hmac.Equal(sig, sumd.signature(parts[0])) {
+		return nil, ErrInvalidLink
+	}
+
+	releaseBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	release := &CachedRelease{}
+	err = json.Unmarshal(releaseBytes, release)
+	if err != nil || release.Expiry == nil {
+		return nil, ErrInvalidLink
+	}
+	if release.expired(time.Now()) {
+		return nil, ErrLinkNotFound
+	}
+	return release, nil
+}
+
+// signedLink asserts if a download key is a signed link, signed links are
+// only accepted while stateless links are enabled
+func (sumd *Sumd) signedLink(key string) bool {
+	return sumd.Args.StatelessLinks && strings.Contains(key, signedLinkSeparator)
+}
+
+// lookupLink resolves a download key to its release, signed keys are
+// validated without a link store lookup. The link must download the
+// provided file.
+func (sumd *Sumd) lookupLink(key string, file string) (*CachedRelease, error) {
+	var release *CachedRelease
+	var err error
+	if sumd.signedLink(key) {
+		release, err = sumd.verifySignedLink(key)
+	} else {
+		release, err = sumd.Links.Get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if release.File != file {
+		return nil, ErrLinkNotFound
+	}
+	return release, nil
+}
+
+// consumeLink resolves a download key to its release and records the
+// download, signed keys carry no download limits
+func (sumd *Sumd) consumeLink(key string) (*CachedRelease, error) {
+	if sumd.signedLink(key) {
+		return sumd.verifySignedLink(key)
+	}
+	return sumd.Links.Consume(key, time.Now())
+}
+
+// startDownload records a download of a release file starting at the
+// provided byte offset, resuming a limited link's download at an offset
+// already served is not counted until its resumes run out
+func (sumd *Sumd) startDownload(key string, release *CachedRelease, offset int64) (*CachedRelease, error) {
+	if offset > 0 && release.MaxDownloads > 0 {
+		resumed, err := sumd.Links.Resume(key, time.Now(), offset)
+		if err != ErrResumeRejected {
+			return resumed, err
+		}
+	}
+	return sumd.consumeLink(key)
+}
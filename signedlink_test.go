@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSignedLinks sets up a server issuing signed download links
+func testSignedLinks() func() {
+	previous := sumd
+	sumd = &Sumd{
+		Args:       &Args{StatelessLinks: true},
+		Links:      NewMemoryLinkStore(),
+		LinkSecret: []byte("link secret"),
+	}
+	return func() {
+		sumd = previous
+	}
+}
+
+// testSignedLink signs a download link to the test release file expiring
+// at the provided time
+func testSignedLink(t *testing.T, expiry time.Time) string {
+	key, err := sumd.signLink(testRelease(expiry, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestLookupSignedLink(t *testing.T) {
+	done := testSignedLinks()
+	defer done()
+
+	tests := []struct {
+		name string
+		key  func() string
+		file string
+		err  error
+	}{
+		{"signed link", func() string {
+			return testSignedLink(t, time.Now().Add(time.Hour))
+		}, "mounty.dmg", nil},
+		{"other file", func() string {
+			return testSignedLink(t, time.Now().Add(time.Hour))
+		}, "mounty.exe", ErrLinkNotFound},
+		{"forged signature", func() string {
+			secret := sumd.LinkSecret
+			sumd.LinkSecret = []byte("forged secret")
+			defer func() { sumd.LinkSecret = secret }()
+			return testSignedLink(t, time.Now().Add(time.Hour))
+		}, "mounty.dmg", ErrInvalidLink},
+		{"tampered payload", func() string {
+			parts := strings.Split(testSignedLink(t, time.Now().Add(time.Hour)), signedLinkSeparator)
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+			payload = []byte(strings.Replace(string(payload), "1.7", "1.8", 1))
+			return base64.RawURLEncoding.EncodeToString(payload) + signedLinkSeparator + parts[1]
+		}, "mounty.dmg", ErrInvalidLink},
+		{"truncated signature", func() string {
+			key := testSignedLink(t, time.Now().Add(time.Hour))
+			return key[:len(key)-2]
+		}, "mounty.dmg", ErrInvalidLink},
+		{"expired link", func() string {
+			return testSignedLink(t, time.Now().Add(-time.Second))
+		}, "mounty.dmg", ErrLinkNotFound},
+	}
+	for _, test := range tests {
+		release, err := sumd.lookupLink(test.key(), test.file)
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (release.Product != "mounty" || release.Version != "1.7") {
+			t.Errorf("%s: got %+v, want the test release", test.name, release)
+		}
+	}
+}
+
+func TestLookupSignedLinkDisabled(t *testing.T) {
+	done := testSignedLinks()
+	defer done()
+	key := testSignedLink(t, time.Now().Add(time.Hour))
+
+	// signed links are only accepted while stateless links are enabled
+	sumd.Args.StatelessLinks = false
+	_, err := sumd.lookupLink(key, "mounty.dmg")
+	if err != ErrLinkNotFound {
+		t.Fatalf("got %v, want %v", err, ErrLinkNotFound)
+	}
+	_, err = sumd.consumeLink(key)
+	if err != ErrLinkNotFound {
+		t.Fatalf("got %v consuming the link, want %v", err, ErrLinkNotFound)
+	}
+}
@@ -1,33 +1,94 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"crypto/tls"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"os"
 	"time"
 
 	"github.com/decred/politeia/politeiad/api/v1"
 	"github.com/decred/politeia/politeiad/api/v1/identity"
-	"github.com/decred/politeia/util"
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/dnldd/sumd/politeia"
+	bolt "go.etcd.io/bbolt"
 )
 
+// ErrMetadataNotFound is returned when a record has no checksum metadata for
+// the requested release
+var ErrMetadataNotFound = errors.New("release checksum metadata not found")
+
+// ErrUnrelatedStream is returned when a metadata stream is not one of the
+// checksum metadata streams, other tools may write metadata into the same
+// records
+var ErrUnrelatedStream = errors.New("metadata stream is not a checksum metadata stream")
+
 type Args struct {
 	// the release directory
-	ReleaseDir string `long:"reldir" description:"the release directory" required:"true"`
+	ReleaseDir string `long:"reldir" description:"the release directory, required unless releases are served from an object store"`
 	// the base url of the service
 	BaseUrl string `long:"baseurl" description:"the base url of the service" required:"true"`
 	// the service port
 	Port string `short:"p" long:"port" description:"the listening port" required:"true"`
 	// pi's endpoint
 	Pi string `long:"pi" description:"pi's endpoint" required:"true"`
+	// pi's CA certificate
+	PiCert string `long:"picert" description:"the CA certificate file used to verify pi's tls certificate, the system roots are used if unset"`
+	// pi's pinned certificate fingerprint
+	PiCertFingerprint string `long:"picertfingerprint" description:"the hex encoded sha256 fingerprint of pi's tls certificate, trusts a self signed certificate if no CA certificate is set"`
+	// pi's pinned identity
+	PiIdentity string `long:"piidentity" description:"the pinned public identity file of pi, sumd refuses to start if pi's identity differs"`
+	// pi's request timeout
+	PiTimeout time.Duration `long:"pitimeout" default:"30s" description:"the timeout of a single request to pi"`
+	// pi's rpc user
+	PiRPCUser string `long:"pirpcuser" description:"pi's rpc user, required to index vetted records for verifications without a token"`
+	// pi's rpc pass
+	PiRPCPass string `long:"pirpcpass" description:"pi's rpc pass"`
+	// the inventory sync interval
+	SyncInterval time.Duration `long:"syncinterval" default:"10m" description:"the interval of vetted record inventory syncs, pi's inventory is only synced at startup if zero"`
+	// the conflicting records flag
+	AllowConflicts bool `long:"allowconflicts" description:"verify release files declared with conflicting checksums by vetted records and flag the conflict instead of refusing"`
+	// the admin user
+	AdminUser string `long:"adminuser" description:"the admin user, admin endpoints are disabled if unset"`
+	// the admin pass
+	AdminPass string `long:"adminpass" description:"the admin pass"`
+	// pi's request retries
+	PiRetries int `long:"piretries" default:"3" description:"the number of retries of a failed idempotent request to pi, none if negative"`
+	// the checksum metadata stream ids
+	MetadataStreamIDs []uint64 `long:"metadatastreamid" default:"1" description:"the id of the record metadata streams carrying checksum metadata, may be repeated, other streams are ignored"`
+	// the record cache size
+	RecordCacheSize int `long:"recordcachesize" default:"1024" description:"the maximum number of cached vetted records, records are not cached if zero"`
+	// the record cache lifetime
+	RecordCacheTTL time.Duration `long:"recordcachettl" default:"5m" description:"the duration the latest version of a cached record is used without asking pi"`
+	// the record cache staleness
+	RecordCacheMaxStale time.Duration `long:"recordcachemaxstale" default:"1h" description:"the duration past the ttl a cached record is served while pi is unavailable"`
+	// the data directory, download links are kept in memory if unset
+	DataDir string `long:"datadir" description:"the data directory for persisted download links"`
+	// the stateless download links flag
+	StatelessLinks bool `long:"statelesslinks" description:"issue signed download links that require no link store"`
+	// the download link signing secret
+	LinkSecret string `long:"linksecret" description:"hex encoded secret for signing stateless download links, derived from the server identity if unset"`
+	// the download link lifetime
+	LinkTTL time.Duration `long:"linkttl" default:"24h" description:"the lifetime of download links"`
+	// the download link limit
+	LinkMaxDownloads int `long:"linkmaxdownloads" description:"the maximum number of downloads per link, unlimited if zero"`
+	// the trusted publisher keyring directory
+	KeyringDir string `long:"keyringdir" description:"the directory of trusted OpenPGP publisher keyrings, named [product].asc or [product].gpg"`
+	// the release object store endpoint
+	S3Endpoint string `long:"s3endpoint" description:"the S3 compatible object store endpoint serving releases instead of the release directory"`
+	// the release bucket
+	S3Bucket string `long:"s3bucket" description:"the object store release bucket"`
+	// the release object key prefix
+	S3Prefix string `long:"s3prefix" description:"the object key prefix of releases in the bucket"`
+	// the object store access key
+	S3AccessKey string `long:"s3accesskey" description:"the object store access key"`
+	// the object store secret key
+	S3SecretKey string `long:"s3secretkey" description:"the object store secret key"`
+	// the object store tls flag
+	S3Insecure bool `long:"s3insecure" description:"connect to the object store without tls"`
 }
 
 // CacheRelease represents a cached entry that describes a file
@@ -38,74 +99,267 @@ type CachedRelease struct {
 	Version string `json:"version"`
 	// the file
 	File string `json:"file"`
+	// the verified checksum
+	Checksum string `json:"checksum"`
+	// the checksum algorithm
+	Algorithm string `json:"algorithm"`
 	// the record expiry
-	Expiry *time.Time
+	Expiry *time.Time `json:"expiry"`
+	// the maximum number of downloads, unlimited if zero
+	MaxDownloads int `json:"maxdownloads,omitempty"`
+	// the number of downloads served
+	Downloads int `json:"downloads,omitempty"`
+	// the number of resumed downloads served
+	Resumes int `json:"resumes,omitempty"`
+	// the byte offset the last download was served up to from the start of
+	// the file
+	Served int64 `json:"served,omitempty"`
+}
+
+// LinkPolicy describes the usage limits of a download link
+type LinkPolicy struct {
+	// the link lifetime
+	TTL time.Duration
+	// the maximum number of downloads, unlimited if zero
+	MaxDownloads int
 }
 
 // ChecksumMetadata represents metadata entry for a release
 // record
 type ChecksumMetadata struct {
+	// the payload schema, api.ChecksumSchema if unset
+	Schema string `json:"schema,omitempty"`
 	// the hash of the release
 	Checksum string `json:"checksum"`
+	// the checksum algorithm, sha256 if unset
+	Algorithm string `json:"algorithm,omitempty"`
 	// the name of the software
 	Product string `json:"product"`
 	// the version number of the release
 	Version string `json:"version"`
 	// the filename of the release
 	File string `json:"file"`
+	// the hex encoded ed25519 signature of the release digest
+	Signature string `json:"signature,omitempty"`
+	// the hex encoded ed25519 public key of the publisher
+	PublicKey string `json:"pubkey,omitempty"`
+}
+
+// ChecksumStreams is the set of metadata stream IDs carrying checksum
+// metadata
+type ChecksumStreams map[uint64]bool
+
+// Parse parses a checksum metadata stream, streams of other IDs are
+// unrelated
+func (streams ChecksumStreams) Parse(stream *v1.MetadataStream) (*ChecksumMetadata, error) {
+	if !streams[stream.ID] {
+		return nil, ErrUnrelatedStream
+	}
+	return parseChecksumMetadata(stream)
 }
 
 // Sumd repsents the checksum service
 type Sumd struct {
 	// the service args
 	Args *Args
-	// the release cache
-	Cache *map[string]CachedRelease
+	// the database, only set when a data directory is configured
+	DB *bolt.DB
+	// the download link store
+	Links LinkStore
+	// the stateless download link signing secret
+	LinkSecret []byte
+	// the release store
+	Store ReleaseStore
+	// the release file checksum index, only set for release directories
+	Index *ChecksumIndex
+	// the release catalog hasher
+	Catalog *CatalogHasher
+	// the trusted OpenPGP publisher keyrings, keyed by product
+	Keyrings PGPKeyrings
 	// the cache update ticker
 	Ticker *time.Ticker
 	// the server's identity
 	Fi *identity.FullIdentity
-	// pi's public identity
-	Pipi *identity.PublicIdentity
-	// the http client
-	HttpClient *http.Client
+	// the politeiad client
+	Politeia *politeia.Client
+	// the vetted record cache, only set if records are cached
+	Records *RecordCache
+	// the checksum metadata stream IDs
+	Streams ChecksumStreams
+	// the background record history loader, only set if records are cached
+	History *HistoryLoader
+	// the release index, only set if pi's rpc credentials are provided
+	Releases *ReleaseIndex
+	// the inventory sync ticker
+	SyncTicker *time.Ticker
 }
 
 // Constructor
 func NewSumd(args *Args) (*Sumd, error) {
 	sumd = &Sumd{
-		Args:  args,
-		Cache: &map[string]CachedRelease{},
-		HttpClient: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
-		},
+		Args: args,
+	}
+
+	if sumd.Args.LinkTTL <= 0 {
+		return nil, errors.New("link ttl must be positive")
+	}
+	if sumd.Args.StatelessLinks && sumd.Args.LinkMaxDownloads > 0 {
+		return nil, errors.New("download limits are not supported with stateless links")
 	}
 
 	var err error
+	if sumd.Args.DataDir != "" {
+		sumd.DB, err = OpenDB(sumd.Args.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		sumd.Links, err = NewBoltLinkStore(sumd.DB)
+		if err != nil {
+			return nil, err
+		}
+		log.Println(">>> download links loaded from", sumd.Args.DataDir)
+	} else {
+		sumd.Links = NewMemoryLinkStore()
+	}
+
+	switch {
+	case sumd.Args.S3Endpoint != "":
+		if sumd.Args.S3Bucket == "" {
+			return nil, errors.New("an object store release bucket is required")
+		}
+		sumd.Store, err = NewS3ReleaseStore(sumd.Args.S3Endpoint,
+			sumd.Args.S3AccessKey, sumd.Args.S3SecretKey, !sumd.Args.S3Insecure,
+			sumd.Args.S3Bucket, sumd.Args.S3Prefix)
+		if err != nil {
+			return nil, err
+		}
+		log.Println(">>> serving releases from bucket", sumd.Args.S3Bucket)
+	case sumd.Args.ReleaseDir != "":
+		sumd.Store = NewFileReleaseStore(sumd.Args.ReleaseDir)
+		sumd.Index, err = NewChecksumIndex(sumd.Args.ReleaseDir, sumd.DB)
+		if err != nil {
+			return nil, err
+		}
+		log.Println(">>> watching release directory", sumd.Args.ReleaseDir)
+	default:
+		return nil, errors.New("a release directory or object store is required")
+	}
+	sumd.Catalog = NewCatalogHasher(sumd.Store, sumd.Index)
+
+	sumd.Keyrings, err = LoadPGPKeyrings(sumd.Args.KeyringDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(sumd.Keyrings) > 0 {
+		log.Printf(">>> %d publisher keyrings loaded", len(sumd.Keyrings))
+	}
+
 	sumd.Fi, err = identity.LoadFullIdentity("identity.json")
 	if err != nil {
 		return nil, err
 	}
 	log.Println(">>> identity loaded")
-	sumd.Pipi, err = util.RemoteIdentity(true, sumd.Args.Pi, "")
+	sumd.LinkSecret, err = linkSecret(sumd.Args, sumd.Fi)
 	if err != nil {
 		return nil, err
 	}
-	log.Println(">>> pi public identity fetched")
 
-	// update the release cache every 2 minutes
+	if len(sumd.Args.MetadataStreamIDs) == 0 {
+		return nil, errors.New("at least one checksum metadata stream id is required")
+	}
+	sumd.Streams = make(ChecksumStreams)
+	for _, id := range sumd.Args.MetadataStreamIDs {
+		sumd.Streams[id] = true
+	}
+
+	httpClient, err := politeia.NewHTTPClient(sumd.Args.PiCert, sumd.Args.PiCertFingerprint)
+	if err != nil {
+		return nil, err
+	}
+	var pinned *identity.PublicIdentity
+	if sumd.Args.PiIdentity != "" {
+		pinned, err = identity.LoadPublicIdentity(sumd.Args.PiIdentity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sumd.Politeia = politeia.NewClient(politeia.Config{
+		Host:       sumd.Args.Pi,
+		HTTPClient: httpClient,
+		Identity:   pinned,
+		RPCUser:    sumd.Args.PiRPCUser,
+		RPCPass:    sumd.Args.PiRPCPass,
+		Timeout:    sumd.Args.PiTimeout,
+		Retries:    sumd.Args.PiRetries,
+	})
+	pipi, err := sumd.Politeia.Identity(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf(">>> pi public identity fetched: %s", pipi)
+
+	if sumd.Args.RecordCacheSize > 0 {
+		if sumd.Args.RecordCacheTTL <= 0 || sumd.Args.RecordCacheMaxStale < 0 {
+			return nil, errors.New("record cache ttl must be positive and its staleness must not be negative")
+		}
+		sumd.Records, err = NewRecordCache(sumd.DB, pipi, sumd.Args.RecordCacheTTL,
+			sumd.Args.RecordCacheMaxStale, sumd.Args.RecordCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		sumd.History = NewHistoryLoader()
+	}
+
+	if sumd.Args.PiRPCUser != "" {
+		sumd.Releases, err = NewReleaseIndex(sumd.DB, sumd.Streams)
+		if err != nil {
+			return nil, err
+		}
+		err = sumd.syncReleases(context.Background())
+		if err != nil {
+			// the persisted release history is used until the next sync
+			if sumd.Releases.Synced().Unix() == 0 {
+				return nil, err
+			}
+			log.Printf("failed to sync vetted releases, using release history of %s: %s",
+				sumd.Releases.Synced(), err)
+		} else {
+			log.Println(">>> vetted releases synced")
+		}
+
+		if sumd.Args.SyncInterval > 0 {
+			sumd.SyncTicker = time.NewTicker(sumd.Args.SyncInterval)
+			go func() {
+				for range sumd.SyncTicker.C {
+					ctx, cancel := context.WithTimeout(context.Background(), sumd.Args.SyncInterval)
+					err := sumd.syncReleases(ctx)
+					cancel()
+					if err != nil {
+						log.Printf("failed to sync vetted releases: %s", err)
+					}
+				}
+			}()
+		}
+	}
+
+	// sweep expired download links and cached records every 2 minutes
 	sumd.Ticker = time.NewTicker(time.Minute * 2)
 	go func() {
-		now := time.Now()
-		for range sumd.Ticker.C {
-			for k, v := range *sumd.Cache {
-				if now.Before(*v.Expiry) {
-					delete(*sumd.Cache, k)
-				}
+		for now := range sumd.Ticker.C {
+			removed, err := sumd.Links.Sweep(now)
+			if err != nil {
+				log.Printf("failed to sweep download links: %s", err)
+			} else if removed > 0 {
+				log.Printf(">>> %d expired download links removed", removed)
+			}
+			if sumd.Records == nil {
+				continue
+			}
+			removed, err = sumd.Records.Sweep(now)
+			if err != nil {
+				log.Printf("failed to sweep cached records: %s", err)
+			} else if removed > 0 {
+				log.Printf(">>> %d expired cached records removed", removed)
 			}
 		}
 	}()
@@ -113,9 +367,34 @@ func NewSumd(args *Args) (*Sumd, error) {
 	return sumd, nil
 }
 
+// Close stops the background jobs and closes the checksum index and the
+// database
+func (sumd *Sumd) Close() {
+	sumd.Ticker.Stop()
+	if sumd.SyncTicker != nil {
+		sumd.SyncTicker.Stop()
+	}
+	sumd.Catalog.Close()
+	if sumd.Index != nil {
+		err := sumd.Index.Close()
+		if err != nil {
+			log.Printf("failed to close the checksum index: %s", err)
+		}
+	}
+	if sumd.DB != nil {
+		err := sumd.DB.Close()
+		if err != nil {
+			log.Printf("failed to close the database: %s", err)
+		}
+	}
+}
+
 // checksum generates the hex encoded checksum of a file
-func (sumd *Sumd) checksum(file *os.File) (string, error) {
-	hash := sha256.New()
+func (sumd *Sumd) checksum(file io.Reader, algorithm string) (string, error) {
+	hash, err := NewHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
 	if _, err := io.Copy(hash, file); err != nil {
 		return "", fmt.Errorf("failed to copy file: %s", err.Error())
 	}
@@ -131,30 +410,131 @@ func (sumd *Sumd) generateKey() string {
 	return hex.EncodeToString(data)
 }
 
+// releaseChecksum returns the checksum of an open release file, the indexed
+// checksum is used unless the file changed since it was indexed
+func (sumd *Sumd) releaseChecksum(version string, name, releasefile string, file ReleaseFile, algorithm string) (string, error) {
+	if sumd.Index == nil {
+		return sumd.checksum(file, algorithm)
+	}
+
+	info, err := sumd.Store.Stat(name, version, releasefile)
+	if err != nil {
+		return "", err
+	}
+	key := releaseKey(name, version, releasefile)
+	checksum, ok := sumd.Index.Lookup(key, info, algorithm)
+	if ok {
+		return checksum, nil
+	}
+
+	checksum, err = sumd.checksum(file, algorithm)
+	if err != nil {
+		return "", err
+	}
+	sumd.Index.Update(key, info, algorithm, checksum)
+	return checksum, nil
+}
+
+// verifySignature asserts the publisher's signature over the release file
+// digest is valid
+func (sumd *Sumd) verifySignature(metadata *ChecksumMetadata, releaseSum string) error {
+	if metadata.Signature == "" || metadata.PublicKey == "" {
+		return errors.New("release signature and public key must be provided together")
+	}
+	pubkey, err := hex.DecodeString(metadata.PublicKey)
+	if err != nil {
+		return errors.New("release public key is not hex encoded")
+	}
+	publisher, err := identity.PublicIdentityFromBytes(pubkey)
+	if err != nil {
+		return fmt.Errorf("invalid release public key: %s", err)
+	}
+	sigBytes, err := hex.DecodeString(metadata.Signature)
+	if err != nil || len(sigBytes) != identity.SignatureSize {
+		return errors.New("invalid release signature")
+	}
+	digest, err := hex.DecodeString(releaseSum)
+	if err != nil {
+		return err
+	}
+
+	var sig [identity.SignatureSize]byte
+	copy(sig[:], sigBytes)
+	if !publisher.VerifyMessage(digest, sig) {
+		return errors.New("release signature verification failed")
+	}
+	return nil
+}
+
 // getReleaseFile fetches the a release file
-func (sumd *Sumd) getReleaseFile(version string, name, releasefile string, releaseDir string) (*os.File, error) {
-	releasePath := fmt.Sprintf("%s/%s/%s/%s", releaseDir, name, version, releasefile)
-	file, err := os.Open(releasePath)
+func (sumd *Sumd) getReleaseFile(version string, name, releasefile string) (ReleaseFile, error) {
+	file, err := sumd.Store.Open(name, version, releasefile)
 	if err != nil {
-		fmt.Printf("failed to release file: %s", err)
-		return nil, errors.New("release file not found")
+		if _, ok := err.(*ReleasePathError); ok {
+			return nil, err
+		}
+		if err != ErrReleaseNotFound {
+			log.Printf("failed to open release file: %s", err)
+		}
+		return nil, ErrReleaseNotFound
 	}
 	return file, nil
 }
 
-// generateReleaseKey caches a verified release for future downloads
-func (sumd *Sumd) cacheRelease(product string, version string, file string) (string, error) {
-	now := time.Now()
+// linkPolicy returns the download link policy for a request, requested
+// limits may only tighten the configured defaults
+func (sumd *Sumd) linkPolicy(singleUse bool, maxDownloads int, ttl time.Duration) (*LinkPolicy, error) {
+	policy := &LinkPolicy{
+		TTL:          sumd.Args.LinkTTL,
+		MaxDownloads: sumd.Args.LinkMaxDownloads,
+	}
+	if singleUse {
+		maxDownloads = 1
+	}
+	if maxDownloads < 0 || ttl < 0 {
+		return nil, errors.New("download limits must not be negative")
+	}
+	if maxDownloads > 0 {
+		if sumd.Args.StatelessLinks {
+			return nil, errors.New("download limits are not supported with stateless links")
+		}
+		if policy.MaxDownloads == 0 || maxDownloads < policy.MaxDownloads {
+			policy.MaxDownloads = maxDownloads
+		}
+	}
+	if ttl > 0 && ttl < policy.TTL {
+		policy.TTL = ttl
+	}
+	return policy, nil
+}
+
+// cacheRelease caches a verified release for future downloads
+func (sumd *Sumd) cacheRelease(metadata *ChecksumMetadata, policy *LinkPolicy) (string, *CachedRelease, error) {
+	expiry := time.Now().Add(policy.TTL)
 	cachedRelease := &CachedRelease{
-		Product: product,
-		Version: version,
-		File:    file,
-		Expiry:  GetFutureTime(&now, 1, 0, 0, 0),
+		Product:      metadata.Product,
+		Version:      metadata.Version,
+		File:         metadata.File,
+		Checksum:     metadata.Checksum,
+		Algorithm:    metadata.Algorithm,
+		Expiry:       &expiry,
+		MaxDownloads: policy.MaxDownloads,
+	}
+
+	if sumd.Args.StatelessLinks {
+		key, err := sumd.signLink(cachedRelease)
+		return key, cachedRelease, err
 	}
 
 	key := sumd.generateKey()
-	(*sumd.Cache)[key] = *cachedRelease
-	return key, nil
+	if key == "" {
+		return "", nil, errors.New("failed to generate download key")
+	}
+	err := sumd.Links.Put(key, cachedRelease)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, cachedRelease, nil
 }
 
 // formUrl generates the download url for a cached release
@@ -162,122 +542,267 @@ func (sumd *Sumd) formUrl(key string, file string) string {
 	return fmt.Sprintf("%s%s/download/%s/%s", sumd.Args.BaseUrl, sumd.Args.Port, key, file)
 }
 
-// The metadata payload is structured as follows:
+// The metadata payload of a checksum metadata stream is structured as
+// follows:
 // {
+//   "schema": "sumd-checksum-v1", // the payload schema, sumd-checksum-v1 if omitted
 //   "checksum":"hash", // the hash of the release
+//   "algorithm": "sha256", // the checksum algorithm, sha256 if omitted
 // 	 "product": "name", // the name of the software
 //   "version": "version number", // the version number of the release
 //   "file": "filename", // the filename of the release
+//   "signature": "signature", // optional ed25519 signature of the release digest
+//   "pubkey": "public key", // the publisher's ed25519 public key, required with a signature
 // }
 //
-// The payload returned is structured as follows:
-// on successful verification:
-// {
-//   "releasechecksum": "hash",
-//   "distributionchecksum": "hash",
-//   "verified": true,
-//   "download": "url",
-// }
-//
-// on verification failure:
-// {
-//   "releasechecksum": "hash",
-//   "distributionchecksum": "hash",
-//   "verified": false,
-//   "error": "details",
-// }
+// The request and reply payloads are described by VerifyRequest and
+// VerifyReply of the api/v1 package.
+
+// fetchRecord fetches and verifies a version of a vetted politeia record, the
+// latest version if no version is provided. Cached records are used while
+// fresh and served stale while politeiad is unavailable.
+func (sumd *Sumd) fetchRecord(ctx context.Context, token string, version string) (*v1.Record, error) {
+	if sumd.Records == nil {
+		return sumd.Politeia.GetVetted(ctx, token, version)
+	}
+
+	now := time.Now()
+	cached, fresh := sumd.Records.Get(token, version, now)
+	if cached != nil && fresh {
+		return cached, nil
+	}
+	record, err := sumd.Politeia.GetVetted(ctx, token, version)
+	if err != nil {
+		if _, ok := err.(*politeia.UnavailableError); ok && cached != nil {
+			log.Printf(">>> serving stale record %s: %s", token, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	err = sumd.Records.Put(token, version, record, now)
+	if err != nil {
+		log.Printf("failed to cache record %s: %s", recordKey(token, version), err)
+	}
+	return record, nil
+}
+
+// parseChecksumMetadata parses a metadata stream as release checksum
+// metadata and validates it against the checksum schema. The algorithm
+// defaults to sha256 and the schema to the current schema if unset.
+func parseChecksumMetadata(stream *v1.MetadataStream) (*ChecksumMetadata, error) {
+	metadata := &ChecksumMetadata{}
+	err := json.Unmarshal([]byte(stream.Payload), metadata)
+	if err != nil {
+		return nil, fmt.Errorf("metadata stream %d is not json: %s", stream.ID, err)
+	}
+	if metadata.Schema == "" {
+		metadata.Schema = api.ChecksumSchema
+	}
+	if metadata.Schema != api.ChecksumSchema {
+		return nil, fmt.Errorf("metadata stream %d has an unsupported schema %q", stream.ID, metadata.Schema)
+	}
+	if metadata.Checksum == "" || metadata.Product == "" || metadata.Version == "" || metadata.File == "" {
+		return nil, fmt.Errorf("metadata stream %d is missing checksum, product, version or file", stream.ID)
+	}
+	if metadata.Algorithm == "" {
+		metadata.Algorithm = DefaultAlgorithm
+	}
+	hasher, err := NewHasher(metadata.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("metadata stream %d has an unsupported algorithm %q", stream.ID, metadata.Algorithm)
+	}
+	checksum, err := hex.DecodeString(metadata.Checksum)
+	if err != nil || len(checksum) != hasher.Size() {
+		return nil, fmt.Errorf("metadata stream %d checksum is not a hex encoded %s digest", stream.ID, metadata.Algorithm)
+	}
+	// checksums are compared in their lowercase hex encoding
+	metadata.Checksum = hex.EncodeToString(checksum)
+	return metadata, nil
+}
+
+// findMetadata fetches the checksum metadata of a release from a record,
+// unrelated and invalid streams are skipped. An empty algorithm
+// matches the release regardless of the algorithm its checksum was recorded
+// with.
+func (sumd *Sumd) findMetadata(record *v1.Record, product string, version string, filename string, algorithm string) (*ChecksumMetadata, error) {
+	for i := range record.Metadata {
+		metadata, err := sumd.Streams.Parse(&record.Metadata[i])
+		if err != nil {
+			continue
+		}
+		if algorithm != "" && algorithm != metadata.Algorithm {
+			continue
+		}
+		if product == metadata.Product && version == metadata.Version && filename == metadata.File {
+			return metadata, nil
+		}
+	}
+	return nil, ErrMetadataNotFound
+}
 
 // ChecksumVerify verifies the distribution checksum against the actual
-// release checksum, it returns a payload with a download link if the
+// release checksum, it returns a reply with a download link if the
 // checksums match.
-func (sumd *Sumd) verify(token string, product string, version string, filename string) (map[string]interface{}, error) {
-	getVettedPayload := v1.GetVetted{
-		Challenge: hex.EncodeToString(sumd.Fi.Public.Key[:]),
-		Token:     token,
+func (sumd *Sumd) verify(ctx context.Context, request *api.VerifyRequest, policy *LinkPolicy) (*api.VerifyReply, error) {
+	token := request.Token
+	if token == "" {
+		var err error
+		token, err = sumd.resolveToken(request.Product, request.Version, request.File, request.Algorithm)
+		if err != nil {
+			return nil, err
+		}
 	}
-	payloadBytes, err := json.Marshal(getVettedPayload)
+	latest, err := sumd.fetchRecord(ctx, token, "")
 	if err != nil {
 		return nil, err
 	}
-
-	// fetch release record
-	req, err := http.NewRequest("POST", sumd.Args.Pi+v1.GetVettedRoute,
-		bytes.NewReader(payloadBytes))
+	history, err := sumd.recordHistory(ctx, latest)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := sumd.HttpClient.Do(req)
+	record := latest
+	if request.RecordVersion != "" {
+		record, err = findRecordVersion(history.Records, request.RecordVersion)
+		if err != nil && !history.Complete {
+			record, err = sumd.fetchRecord(ctx, token, request.RecordVersion)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	requestedMetadata, err := sumd.findMetadata(record, request.Product, request.Version, request.File, request.Algorithm)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body := util.ConvertBodyToByteArray(resp.Body, false)
-	reply := &v1.GetVettedReply{}
-	err = json.Unmarshal(body, reply)
+	verifyReply, err := sumd.verifyRelease(record, history, requestedMetadata, policy)
 	if err != nil {
 		return nil, err
 	}
-	sigBytes := []byte(reply.Record.CensorshipRecord.Signature)
-	var sig [identity.SignatureSize]byte
-	copy(sig[:], sigBytes)
-
-	// verify pi response & client challenge
-	if sumd.Pipi.VerifyMessage(body, sig) {
-		return nil, errors.New("message verification failed")
+	if sumd.Releases != nil {
+		verifyReply.Tokens = sumd.vouchingTokens(requestedMetadata)
 	}
-	err = util.VerifyChallenge(sumd.Pipi, sumd.Fi.Public.Key[:], reply.Response)
+	return verifyReply, nil
+}
+
+// verifyRelease verifies a release file against the checksum metadata of a
+// record version, a download link is only issued if a link policy is
+// provided. The other available versions of the record's history are
+// checked for changed checksum metadata. The reply is signed as a verification receipt.
+func (sumd *Sumd) verifyRelease(record *v1.Record, history *RecordHistory, requestedMetadata *ChecksumMetadata, policy *LinkPolicy) (*api.VerifyReply, error) {
+	file, err := sumd.getReleaseFile(requestedMetadata.Version, requestedMetadata.Product, requestedMetadata.File)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	// fetch the requested release checksum record
-	requestedMetadata := &ChecksumMetadata{}
-	metadataSet := &reply.Record.Metadata
-	for _, metadata := range *metadataSet {
-		err := json.Unmarshal([]byte(metadata.Payload), requestedMetadata)
+	// a detached OpenPGP signature is checked over the same bytes that are
+	// hashed, the release file is read once for both
+	hasher, err := NewHasher(requestedMetadata.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	pgpResult, err := sumd.verifyPGPSignature(requestedMetadata.Version, requestedMetadata.Product, requestedMetadata.File, io.TeeReader(file, hasher))
+	if err != nil {
+		return nil, err
+	}
+	var releaseSum string
+	if pgpResult != nil && pgpResult.Checked {
+		// the signature check may stop short of the end of the file
+		if _, err := io.Copy(hasher, file); err != nil {
+			return nil, fmt.Errorf("failed to copy file: %s", err.Error())
+		}
+		releaseSum = hex.EncodeToString(hasher.Sum(nil))
+	} else {
+		releaseSum, err = sumd.releaseChecksum(requestedMetadata.Version, requestedMetadata.Product, requestedMetadata.File, file, requestedMetadata.Algorithm)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if product == requestedMetadata.Product && version == requestedMetadata.Version && filename == requestedMetadata.File {
-			break
-		}
+	verifyReply := &api.VerifyReply{
+		Token:                record.CensorshipRecord.Token,
+		RecordVersion:        record.Version,
+		Product:              requestedMetadata.Product,
+		Version:              requestedMetadata.Version,
+		File:                 requestedMetadata.File,
+		ReleaseChecksum:      releaseSum,
+		DistributionChecksum: requestedMetadata.Checksum,
+		Algorithm:            requestedMetadata.Algorithm,
 	}
 
-	if requestedMetadata.Checksum == "" {
-		return nil, fmt.Errorf("no metadata found for record with token %s", token)
+	// vetted records must agree on the release checksum
+	if sumd.Releases != nil {
+		verifyReply.ConflictingTokens = sumd.conflictingTokens(record.CensorshipRecord.Token, requestedMetadata)
+		if len(verifyReply.ConflictingTokens) > 0 && !sumd.Args.AllowConflicts {
+			return nil, ErrConflictingRecords
+		}
 	}
 
-	file, err := sumd.getReleaseFile(requestedMetadata.Version, requestedMetadata.Product, requestedMetadata.File, sumd.Args.ReleaseDir)
-	if err != nil {
-		return nil, err
+	// the checksum metadata should not change between record versions,
+	// the check is unavailable if earlier versions are missing
+	verifyReply.MetadataChanges = sumd.metadataChanges(history.Records, record, requestedMetadata)
+	verifyReply.HistoryIncomplete = !history.Complete
+	if len(verifyReply.MetadataChanges) > 0 {
+		verifyReply.MetadataChanged = true
+		log.Printf(">>> ALERT: record %s declares changed checksum metadata for %s/%s/%s across versions",
+			record.CensorshipRecord.Token, requestedMetadata.Product, requestedMetadata.Version, requestedMetadata.File)
 	}
-	defer file.Close()
 
-	releaseSum, err := sumd.checksum(file)
-	if err != nil {
-		return nil, err
+	// a declared publisher signature must be valid
+	var signatureErr error
+	if requestedMetadata.Signature != "" || requestedMetadata.PublicKey != "" {
+		signatureErr = sumd.verifySignature(requestedMetadata, releaseSum)
+		verifyReply.SignatureVerified = signatureErr == nil
 	}
 
-	payload := map[string]interface{}{
-		"releasechecksum":      releaseSum,
-		"distributionchecksum": requestedMetadata.Checksum,
+	// a detached OpenPGP signature must be valid, signatures that cannot be
+	// checked against a trusted keyring fail the verification
+	if pgpResult != nil {
+		verifyReply.PGPSignature = pgpResult.Signature
+		verifyReply.PGPVerified = pgpResult.Verified
+		verifyReply.PGPSigner = pgpResult.Signer
+		if pgpResult.Err != nil {
+			verifyReply.PGPError = pgpResult.Err.Error()
+		}
+		if !pgpResult.Checked && signatureErr == nil {
+			signatureErr = fmt.Errorf("detached signature %s could not be checked", pgpResult.Signature)
+		}
+		if pgpResult.Checked && !pgpResult.Verified && signatureErr == nil {
+			signatureErr = fmt.Errorf("detached signature %s is invalid", pgpResult.Signature)
+		}
 	}
 
-	if requestedMetadata.Checksum == releaseSum {
-		payload["verified"] = true
-		key, err := sumd.cacheRelease(requestedMetadata.Product, requestedMetadata.Version, requestedMetadata.File)
+	if requestedMetadata.Checksum == releaseSum && signatureErr == nil {
+		verifyReply.Verified = true
+		if policy == nil {
+			sumd.signReceipt(verifyReply)
+			return verifyReply, nil
+		}
+		key, cachedRelease, err := sumd.cacheRelease(requestedMetadata, policy)
 		if err != nil {
 			return nil, fmt.Errorf("failed to cache release file: %s", err)
 		}
-		url := sumd.formUrl(key, requestedMetadata.File)
-		payload["download"] = url
+		verifyReply.Download = sumd.formUrl(key, requestedMetadata.File)
+		verifyReply.Expires = cachedRelease.Expiry
+		if cachedRelease.MaxDownloads > 0 {
+			verifyReply.RemainingDownloads = cachedRelease.RemainingDownloads()
+		}
+	} else if signatureErr != nil {
+		verifyReply.Error = &api.ErrorReply{
+			ErrorCode: api.ErrorStatusInvalidSignature,
+			ErrorContext: []string{
+				fmt.Sprintf("publisher signature check failed for the requested file, the download has been aborted for your safety: %s.", signatureErr),
+			},
+		}
 	} else {
-		payload["verified"] = false
-		payload["error"] = map[string]string{
-			"msg": "data integrity check failed for the requested file, the download has been aborted for your safety.",
+		verifyReply.Error = &api.ErrorReply{
+			ErrorCode: api.ErrorStatusChecksumMismatch,
+			ErrorContext: []string{
+				"data integrity check failed for the requested file, the download has been aborted for your safety.",
+			},
 		}
 	}
 
-	return payload, nil
+	sumd.signReceipt(verifyReply)
+	return verifyReply, nil
 }
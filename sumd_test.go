@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+func TestParseChecksumMetadata(t *testing.T) {
+	checksum := "5eccbbea3f91c9646a6c9d1462137b68e9a81b8d860b3855011e9fe6dcc3f280"
+	tests := []struct {
+		name     string
+		payload  string
+		checksum string
+		valid    bool
+	}{
+		{"lowercase checksum", `{"checksum":"` + checksum + `","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+			checksum, true},
+		{"uppercase checksum", `{"checksum":"` + strings.ToUpper(checksum) + `","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+			checksum, true},
+		{"mixed case checksum", `{"checksum":"5ECCbbea` + checksum[8:] + `","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+			checksum, true},
+		{"short checksum", `{"checksum":"5ecc","product":"mounty","version":"1.7","file":"mounty.dmg"}`, "", false},
+		{"non hex checksum", `{"checksum":"` + strings.Repeat("z", 64) + `","product":"mounty","version":"1.7","file":"mounty.dmg"}`,
+			"", false},
+	}
+	for _, test := range tests {
+		metadata, err := parseChecksumMetadata(&v1.MetadataStream{ID: 1, Payload: test.payload})
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: parsed invalid checksum metadata", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if metadata.Checksum != test.checksum {
+			t.Errorf("%s: got checksum %s, want %s", test.name, metadata.Checksum, test.checksum)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	publisher, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseSum := testDigest(testReleaseContent)
+	digest, _ := hex.DecodeString(releaseSum)
+	signature := publisher.SignMessage(digest)
+	otherSignature := other.SignMessage(digest)
+	pubkey := hex.EncodeToString(publisher.Public.Key[:])
+	flipped := signature
+	flipped[0] ^= 0x01
+
+	tests := []struct {
+		name      string
+		signature string
+		pubkey    string
+		valid     bool
+	}{
+		{"valid signature", hex.EncodeToString(signature[:]), pubkey, true},
+		{"wrong key", hex.EncodeToString(signature[:]), hex.EncodeToString(other.Public.Key[:]), false},
+		{"other signer", hex.EncodeToString(otherSignature[:]), pubkey, false},
+		{"flipped signature", hex.EncodeToString(flipped[:]), pubkey, false},
+		{"truncated signature", hex.EncodeToString(signature[1:]), pubkey, false},
+		{"non hex signature", "zz" + hex.EncodeToString(signature[1:]), pubkey, false},
+		{"malformed key", hex.EncodeToString(signature[:]), pubkey[2:], false},
+		{"missing key", hex.EncodeToString(signature[:]), "", false},
+		{"missing signature", "", pubkey, false},
+	}
+	for _, test := range tests {
+		err := sumd.verifySignature(&ChecksumMetadata{
+			Signature: test.signature,
+			PublicKey: test.pubkey,
+		}, releaseSum)
+		if test.valid && err != nil {
+			t.Errorf("%s: got %v, want a valid signature", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: got a valid signature, want an error", test.name)
+		}
+	}
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -14,18 +15,28 @@ import (
 	"github.com/decred/politeia/politeiad/api/v1"
 	"github.com/decred/politeia/politeiad/api/v1/identity"
 	"github.com/decred/politeia/util"
+	api "github.com/dnldd/sumd/api/v1"
+	"github.com/dnldd/sumd/politeia"
 )
 
 // the cli args for sumdemo
 type Args struct {
 	// pi's endpoint
 	Pi string `long:"pi" description:"pi's endpoint" required:"true"`
+	// pi's CA certificate
+	PiCert string `long:"picert" description:"the CA certificate file used to verify pi's tls certificate, the system roots are used if unset"`
+	// pi's pinned certificate fingerprint
+	PiCertFingerprint string `long:"picertfingerprint" description:"the hex encoded sha256 fingerprint of pi's tls certificate, trusts a self signed certificate if no CA certificate is set"`
+	// pi's pinned identity
+	PiIdentity string `long:"piidentity" description:"the pinned public identity file of pi"`
 	// sumd's endpoint
 	Sumd string `long:"sumd" description:"sumd's endpoint" required:"true"`
 	// the rpc user
 	RPCUser string `long:"rpcuser" description:"the rpc user" required:"true"`
 	// the rpc pass
 	RPCPass string `long:"rpcpass" description:"the rpc pass" required:"true"`
+	// the checksum metadata stream id
+	MetadataStreamID uint64 `long:"metadatastreamid" default:"1" description:"the id of the record metadata stream carrying the checksum metadata"`
 	// the flag to run failure case
 	Fail bool `long:"fail" description:"run failure case"`
 }
@@ -37,26 +48,16 @@ type Args struct {
 var (
 	// args
 	args = &Args{}
-	// verify endpoint
-	verifyRoute = "/verify"
-	// the http client
-	client = http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-	}
-	// the full identity of the client
-	fi *identity.FullIdentity
-	// the public identity of pi
-	pipi *identity.PublicIdentity
+	// the sumd http client
+	client = &http.Client{}
+	// the pi client
+	pi *politeia.Client
+	// the public identity of sumd
+	sumdpi *identity.PublicIdentity
 	// the censorship record
 	censorshipRecord *v1.CensorshipRecord
-	// the checksum metadata records
-	checksumRecords *[]v1.MetadataStream
-	// the release file checksum payload
-	checksumPayload *map[string]interface{}
+	// the release file verification reply
+	verifyReply *api.VerifyReply
 )
 
 // prettyPrint json pretty printer
@@ -75,6 +76,7 @@ func postRecord() error {
 	if !args.Fail {
 		// payload for success case
 		checksumRecord = map[string]interface{}{
+			"schema":   api.ChecksumSchema,
 			"checksum": "5eccbbea3f91c9646a6c9d1462137b68e9a81b8d860b3855011e9fe6dcc3f280",
 			"product":  "mounty",
 			"version":  "1.7",
@@ -83,6 +85,7 @@ func postRecord() error {
 	} else {
 		// payload for failure case
 		checksumRecord = map[string]interface{}{
+			"schema":   api.ChecksumSchema,
 			"checksum": "5eccbbea3f91c9646a6c9d1462137b68e9a81b8d860b3855011e9fe6dcc3f281",
 			"product":  "mounty",
 			"version":  "1.7",
@@ -94,7 +97,7 @@ func postRecord() error {
 		return err
 	}
 	metadata := v1.MetadataStream{
-		ID:      1,
+		ID:      args.MetadataStreamID,
 		Payload: string(checksumRecordBytes),
 	}
 	name := "mounty-v1.7.md"
@@ -108,68 +111,48 @@ func postRecord() error {
 		Digest:  digest,
 		Payload: content,
 	}
-	payload := v1.NewRecord{
-		Challenge: hex.EncodeToString(fi.Public.Key[:]),
-		//hex.EncodeToString(challenge),
-		Metadata: []v1.MetadataStream{metadata},
-		Files:    []v1.File{file},
-	}
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
 
-	// post new pi record
-	req, err := http.NewRequest("POST", args.Pi+v1.NewRecordRoute,
-		bytes.NewReader(payloadBytes))
+	// post new pi record, the censorship record is verified by the client
+	record, err := pi.NewRecord(context.Background(),
+		[]v1.MetadataStream{metadata}, []v1.File{file})
 	if err != nil {
 		return err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body := util.ConvertBodyToByteArray(resp.Body, false)
-	reply := &v1.NewRecordReply{}
-	err = json.Unmarshal(body, reply)
+	censorshipRecord = record
+	recordBytes, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
+	log.Printf(">>> record created:\n%s\n", prettyPrint(&recordBytes))
+	return nil
+}
 
-	// verify server response & client challenge
-	sigBytes := []byte(reply.CensorshipRecord.Signature)
-	var sig [identity.SignatureSize]byte
-	copy(sig[:], sigBytes)
-	if pipi.VerifyMessage(body, sig) {
-		return errors.New("message verification failed")
-	}
-	err = util.VerifyChallenge(pipi, fi.Public.Key[:], reply.Response)
+// publishRecord updates a record unvetted status to be publicly visible
+func publishRecord() error {
+	err := pi.SetUnvettedStatus(context.Background(), censorshipRecord.Token,
+		v1.RecordStatusPublic, nil, nil)
 	if err != nil {
 		return err
 	}
-	censorshipRecord = &reply.CensorshipRecord
-	log.Printf(">>> record created:\n%s\n", prettyPrint(&body))
+	log.Printf(">>> record %s updated, now publicly visible.\n", censorshipRecord.Token)
 	return nil
 }
 
-// publishRecord updates a record unvetted status to be publicly visible
-func publishRecord() error {
-	// create record update payload
-	payload := v1.SetUnvettedStatus{
-		Challenge: hex.EncodeToString(fi.Public.Key[:]),
-		Token:     censorshipRecord.Token,
-		Status:    v1.RecordStatusPublic,
+// fetchSumdIdentity fetches sumd's public identity and asserts sumd holds
+// the matching private key
+func fetchSumdIdentity() error {
+	challenge, err := util.Random(api.ChallengeSize)
+	if err != nil {
+		return err
 	}
-	payloadBytes, err := json.Marshal(payload)
+	payloadBytes, err := json.Marshal(api.Identity{
+		Challenge: hex.EncodeToString(challenge),
+	})
 	if err != nil {
 		return err
 	}
-
-	// post vetting status update
-	req, err := http.NewRequest("POST", args.Pi+v1.SetUnvettedStatusRoute,
+	req, err := http.NewRequest("POST", args.Sumd+api.IdentityRoute,
 		bytes.NewReader(payloadBytes))
-	req.SetBasicAuth(args.RPCUser, args.RPCPass)
 	if err != nil {
 		return err
 	}
@@ -179,80 +162,36 @@ func publishRecord() error {
 	}
 	defer resp.Body.Close()
 	body := util.ConvertBodyToByteArray(resp.Body, false)
-	reply := &v1.SetUnvettedStatusReply{}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("identity request failed: %s", resp.Status)
+	}
+	reply := &api.IdentityReply{}
 	err = json.Unmarshal(body, reply)
 	if err != nil {
 		return err
 	}
-
-	// verify client challenge
-	err = util.VerifyChallenge(pipi, fi.Public.Key[:], reply.Response)
+	id, err := util.IdentityFromString(reply.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = util.VerifyChallenge(id, challenge, reply.Response)
 	if err != nil {
 		return err
 	}
-	log.Printf(">>> record updated, now publicly visible:\n%s\n", prettyPrint(&body))
+	sumdpi = id
+	log.Printf(">>> sumd public identity fetched: %s", reply.PublicKey)
 	return nil
 }
 
-// fetchChecksumRecords fetch checksum metadata from a publish pi record
-// func fetchChecksumRecords() error {
-// 	// create record retrieval payload
-// 	payload := v1.GetVetted{
-// 		Challenge: hex.EncodeToString(fi.Public.Key[:]),
-// 		Token:     censorshipRecord.Token,
-// 	}
-// 	payloadBytes, err := json.Marshal(payload)
-// 	if err != nil {
-// 		return err
-// 	}
-//
-// 	// request pi record
-// 	req, err := http.NewRequest("POST", args.Pi+v1.GetVettedRoute,
-// 		bytes.NewReader(payloadBytes))
-// 	if err != nil {
-// 		return err
-// 	}
-// 	resp, err := client.Do(req)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer resp.Body.Close()
-// 	body := util.ConvertBodyToByteArray(resp.Body, false)
-// 	reply := &v1.GetVettedReply{}
-// 	err = json.Unmarshal(body, reply)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	sigBytes := []byte(reply.Record.CensorshipRecord.Signature)
-// 	var sig [identity.SignatureSize]byte
-// 	copy(sig[:], sigBytes)
-//
-// 	// verify pi response & client challenge
-// 	if pipi.VerifyMessage(body, sig) {
-// 		return errors.New("message verification failed")
-// 	}
-// 	err = util.VerifyChallenge(pipi, fi.Public.Key[:], reply.Response)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	checksumRecords = &reply.Record.Metadata
-// 	recordBytes, err := json.Marshal(*checksumRecords)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	log.Printf(">>> pi record retrieved, release information obtained from metadata:\n%s\n", prettyPrint(&recordBytes))
-// 	return nil
-// }
-
 // VerifyRelease asserts the integrity of a release file
-func VerifyRelease(payload *map[string]interface{}) error {
-	payloadBytes, err := json.Marshal(*payload)
+func VerifyRelease(payload *api.VerifyRequest) error {
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 	// verify release info with sumd
 	log.Println(">>> verifying release information with sumd...")
-	req, err := http.NewRequest("POST", args.Sumd+verifyRoute,
+	req, err := http.NewRequest("POST", args.Sumd+api.VerifyRoute,
 		bytes.NewReader(payloadBytes))
 	if err != nil {
 		return err
@@ -263,54 +202,88 @@ func VerifyRelease(payload *map[string]interface{}) error {
 	}
 	defer resp.Body.Close()
 	body := util.ConvertBodyToByteArray(resp.Body, false)
-	reply := &map[string]interface{}{}
+	if resp.StatusCode != http.StatusOK {
+		errorReply := api.ErrorReply{}
+		err = json.Unmarshal(body, &errorReply)
+		if err != nil {
+			return fmt.Errorf("verification request failed: %s", resp.Status)
+		}
+		return errorReply
+	}
+	reply := &api.VerifyReply{}
 	err = json.Unmarshal(body, reply)
 	if err != nil {
 		return err
 	}
-	checksumPayload = reply
+
+	// verify the receipt was signed by sumd
+	if sumdpi == nil {
+		return errors.New("sumd identity is unknown")
+	}
+	err = api.VerifyReceipt(*sumdpi, reply)
+	if err != nil {
+		return err
+	}
+	log.Println(">>> verification receipt signed by sumd.")
+	verifyReply = reply
 	log.Printf(">>> results:\n%s\n", prettyPrint(&body))
 	return nil
 }
 
+// DownloadReleaseFile downloads a verified release file
 func DownloadReleaseFile() error {
-	verified, hasVerified := (*checksumPayload)["verified"].(bool)
-	if hasVerified {
-		if verified {
-			// download release file
-			log.Println(">>> release file verified, downloading...")
-			url, _ := (*checksumPayload)["download"].(string)
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				return err
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			body := util.ConvertBodyToByteArray(resp.Body, false)
-			log.Printf(">>> %d bytes downloaded.", len(body))
-			return nil
-		}
+	if verifyReply == nil {
+		return errors.New("release file has not been verified")
+	}
+	if !verifyReply.Verified {
 		log.Println(">>> failed to verify release file, aborting download.")
-		detail, _ := (*checksumPayload)["error"].(string)
-		return errors.New(detail)
+		if verifyReply.Error != nil {
+			return verifyReply.Error
+		}
+		return errors.New("release file verification failed")
+	}
+
+	// download release file
+	log.Println(">>> release file verified, downloading...")
+	req, err := http.NewRequest("GET", verifyReply.Download, nil)
+	if err != nil {
+		return err
 	}
-	return errors.New("malformed checksum payload")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body := util.ConvertBodyToByteArray(resp.Body, false)
+	log.Printf(">>> %d bytes downloaded.", len(body))
+	return nil
 }
 
 func main() {
 	spew.Config.Indent = "\t"
 	_, err := flags.Parse(args)
 	if err == nil {
-		fi, err = identity.LoadFullIdentity("../identity.json")
+		piClient, err := politeia.NewHTTPClient(args.PiCert, args.PiCertFingerprint)
 		if err != nil {
-			log.Println(err)
+			log.Fatal(err)
 		}
-		pipi, err = util.RemoteIdentity(true, args.Pi, "")
+		var pinned *identity.PublicIdentity
+		if args.PiIdentity != "" {
+			pinned, err = identity.LoadPublicIdentity(args.PiIdentity)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		pi = politeia.NewClient(politeia.Config{
+			Host:       args.Pi,
+			HTTPClient: piClient,
+			Identity:   pinned,
+			RPCUser:    args.RPCUser,
+			RPCPass:    args.RPCPass,
+		})
+		_, err = pi.Identity(context.Background())
 		if err != nil {
-			log.Println(err)
+			log.Fatal(err)
 		}
 		err = postRecord()
 		if err != nil {
@@ -321,14 +294,19 @@ func main() {
 			log.Println(err)
 		}
 
-		verifyPayload := map[string]interface{}{
-			"token":   censorshipRecord.Token,
-			"product": "mounty",
-			"version": "1.7",
-			"file":    "mounty.dmg",
+		err = fetchSumdIdentity()
+		if err != nil {
+			log.Println(err)
+		}
+
+		verifyPayload := api.VerifyRequest{
+			Token:   censorshipRecord.Token,
+			Product: "mounty",
+			Version: "1.7",
+			File:    "mounty.dmg",
 		}
 
-		err := VerifyRelease(&verifyPayload)
+		err = VerifyRelease(&verifyPayload)
 		if err != nil {
 			log.Println(err)
 		}